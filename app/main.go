@@ -13,24 +13,34 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/rs/zerolog/log"
 	"github.com/spf13/pflag"
 	"golang.org/x/sync/errgroup"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
-	"github.com/Tsonov/cast-taler/app/modules/echo"
+	_ "github.com/Tsonov/cast-taler/app/modules/echo"
+	_ "github.com/Tsonov/cast-taler/app/modules/grpcecho"
+	_ "github.com/Tsonov/cast-taler/app/modules/memory"
+	_ "github.com/Tsonov/cast-taler/app/modules/tcpstream"
+	_ "github.com/Tsonov/cast-taler/app/modules/udp"
 	"github.com/Tsonov/cast-taler/app/pkg/k8s"
 	"github.com/Tsonov/cast-taler/app/pkg/metrics"
+	"github.com/Tsonov/cast-taler/app/pkg/module"
 	"github.com/Tsonov/cast-taler/app/pkg/server"
 )
 
 var (
-	modules                      = pflag.StringSlice("module", nil, "modules to run")
-	silent                       = pflag.Bool("silent", false, "silence the logger")
-	failOnSignal                 = pflag.Bool("fail-on-signal", true, "fail on SIGTERM/SIGINT signal")
-	readinessPort                = pflag.String("readiness-port", "8081", "port for kubernetes readiness check")
-	nodeName                     = pflag.String("node-name", "", "name of the node, used for readiness check")
-	zoneConfigPath               = pflag.String("zone-config-path", "", "path to the zone config file")
-	clientRequestNumberPerSecond = pflag.Int("client-request-number-per-second", 10, "number of requests per second for echo client")
+	modules        = pflag.StringSlice("module", nil, "modules to run")
+	listModules    = pflag.Bool("list-modules", false, "print the available modules and their flags, then exit")
+	silent         = pflag.Bool("silent", false, "silence the logger")
+	failOnSignal   = pflag.Bool("fail-on-signal", true, "fail on SIGTERM/SIGINT signal")
+	readinessPort  = pflag.String("readiness-port", "8081", "port for kubernetes readiness check")
+	nodeName       = pflag.String("node-name", "", "name of the node, used for readiness check")
+	zoneConfigPath = pflag.String("zone-config-path", "", "path to the zone config file")
+	reloadInterval = pflag.Duration("zone-config-reload-interval", 10*time.Second, "how often the zone config file is checked for changes, 0 disables reloading")
+	scenarioPath   = pflag.String("fault-scenario-path", "", "path to a fault scenario file, its phases replace zones of the zone config on a schedule")
+	trafficScale   = pflag.Float64("traffic-scale-factor", 1, "multiply reported traffic by this factor, exported as traffic_scale_factor, only meant for demos")
+	validateConfig = pflag.Bool("validate-zone-config", false, "validate the file at --zone-config-path and exit, non zero when it is invalid")
+	knownZones     = pflag.StringSlice("known-zones", nil, "zone names of the cluster, --validate-zone-config then also fails on keys that apply to none of them")
 )
 
 // startReadinessServer starts an HTTP server for Kubernetes readiness checks
@@ -54,7 +64,7 @@ func startReadinessServer(ctx context.Context, logger *slog.Logger, isReady *ato
 	go func() {
 		logger.Info("Starting readiness server on port " + *readinessPort)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Error("Readiness server failed", log.Err(err))
+			logger.Error("Readiness server failed", slog.Any("error", err))
 		}
 	}()
 
@@ -65,9 +75,59 @@ func startReadinessServer(ctx context.Context, logger *slog.Logger, isReady *ato
 	return server.Shutdown(shutdownCtx)
 }
 
+// printModules writes the registered modules and their flags to w
+func printModules(w io.Writer) {
+	for _, m := range module.List() {
+		fmt.Fprintf(w, "%s\t%s\n", m.Name(), m.Description())
+		if f := m.Flags(); f != nil && f.HasFlags() {
+			fmt.Fprint(w, f.FlagUsagesWrapped(0))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
 func main() {
+	module.AddFlags(pflag.CommandLine)
 	pflag.Parse()
 
+	if *listModules {
+		printModules(os.Stdout)
+		return
+	}
+
+	if *validateConfig {
+		cfg, err := server.LoadZoneConfig(*zoneConfigPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if len(*knownZones) > 0 {
+			if err := cfg.CheckZones(*knownZones); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", *zoneConfigPath, err)
+				os.Exit(1)
+			}
+		}
+		fmt.Printf("%s is valid\n", *zoneConfigPath)
+		if *scenarioPath != "" {
+			scenario, err := server.LoadScenario(*scenarioPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			for _, phase := range scenario.Phases {
+				if len(*knownZones) == 0 {
+					break
+				}
+				if err := phase.Zones.CheckZones(*knownZones); err != nil {
+					fmt.Fprintf(os.Stderr, "%s: phase %s: %v\n", *scenarioPath, phase.Name, err)
+					os.Exit(1)
+				}
+			}
+			fmt.Printf("%s is valid\n", *scenarioPath)
+		}
+		return
+	}
+
 	if *silent {
 		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
 	} else {
@@ -78,14 +138,30 @@ func main() {
 
 	logger := slog.Default().With("module", "main")
 
+	selected, err := module.Resolve(*modules)
+	if err != nil {
+		logger.Error("Invalid module selection", slog.Any("error", err))
+		os.Exit(2)
+	}
+
+	if *trafficScale <= 0 {
+		logger.Error("Traffic scale factor must be positive", slog.Float64("traffic-scale-factor", *trafficScale))
+		os.Exit(2)
+	}
+	if *trafficScale != 1 {
+		logger.Warn("Reported traffic is scaled, traffic metrics do not show real bytes", slog.Float64("traffic-scale-factor", *trafficScale))
+	}
+	metrics.SetTrafficScale(*trafficScale)
+
 	signalCtx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
 	availabilityZone := ""
+	var k8sClient client.WithWatch
 	//TODO: for experimenting with binary locally, remove this check later
 
 	if nodeName != nil && *nodeName != "" {
-		k8sClient, err := k8s.NewClient()
+		k8sClient, err = k8s.NewClient()
 		if err != nil {
 			logger.Error("Failed to create Kubernetes client", slog.Any("error", err))
 			return
@@ -102,7 +178,7 @@ func main() {
 	zoneConfig, err := server.LoadZoneConfig(*zoneConfigPath)
 	if err != nil {
 		logger.Error("Failed to load zone config", slog.Any("error", err))
-		return
+		os.Exit(1)
 	}
 
 	// Pod name is set as hostname. Since we control the deployment we can be
@@ -113,25 +189,53 @@ func main() {
 		os.Exit(1)
 	}
 
+	var scenario *server.Scenario
+	if *scenarioPath != "" {
+		scenario, err = server.LoadScenario(*scenarioPath)
+		if err != nil {
+			logger.Error("Failed to load fault scenario", slog.Any("error", err))
+			os.Exit(1)
+		}
+	}
+
+	var ready atomic.Bool
+	readinessStarted := false
+	zoneConfigHash, _ := server.FileHash(*zoneConfigPath)
+	zoneConfigStore := server.NewZoneConfigStore(zoneConfig, zoneConfigHash)
 	runGroup, groupCtx := errgroup.WithContext(signalCtx)
-	for _, module := range *modules {
-		logger := slog.Default().With("module", module)
-		switch module {
-		case "echo-client":
-			runGroup.Go(func() error {
-				return echo.NewEchoClient(logger, availabilityZone, podName).Run(groupCtx, *clientRequestNumberPerSecond)
-			})
-		case "echo-server":
-			var ready atomic.Bool
+	if *reloadInterval > 0 {
+		watcher := server.NewConfigWatcher(logger.With("module", "zone-config"), *zoneConfigPath, zoneConfigStore, *reloadInterval)
+		runGroup.Go(func() error {
+			return watcher.Run(groupCtx)
+		})
+	}
+	if scenario != nil {
+		runner := server.NewScenarioRunner(logger.With("module", "fault-scenario"), scenario, zoneConfigStore)
+		runGroup.Go(func() error {
+			return runner.Run(groupCtx)
+		})
+	}
+	for _, m := range selected {
+		env := module.Env{
+			Logger:           slog.Default().With("module", m.Name()),
+			AvailabilityZone: availabilityZone,
+			PodName:          podName,
+			NodeName:         *nodeName,
+			ZoneConfig:       zoneConfigStore,
+			Ready:            &ready,
+			K8sClient:        k8sClient,
+		}
+		if _, ok := m.(module.ReadinessReporter); ok && !readinessStarted {
+			readinessStarted = true
 			go func() {
 				if err := startReadinessServer(context.Background(), logger.With("module", "readiness"), &ready); err != nil {
 					panic(err)
 				}
 			}()
-			runGroup.Go(func() error {
-				return echo.NewEchoServer(logger, availabilityZone, podName, zoneConfig, &ready).Run(groupCtx)
-			})
 		}
+		runGroup.Go(func() error {
+			return m.Run(groupCtx, env)
+		})
 	}
 
 	runGroup.Go(func() error {
@@ -164,7 +268,7 @@ func main() {
 	}
 
 	if result != nil {
-		logger.Error("Module failed", log.Err(result))
+		logger.Error("Module failed", slog.Any("error", result))
 		os.Exit(99)
 	}
 }
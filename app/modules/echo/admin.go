@@ -0,0 +1,171 @@
+package echo
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/Tsonov/cast-taler/app/pkg/server"
+)
+
+var (
+	adminPort      = serverFlags.Int("echo-server-admin-port", 0, "port of the admin API, 0 disables it")
+	adminTokenFile = serverFlags.String("echo-server-admin-token-file", "", "file holding the bearer token required by the admin API")
+)
+
+// maxAdminBody limits zone configs sent to the admin API
+const maxAdminBody = 1 * MB
+
+// adminStatus is returned by GET /admin/status
+type adminStatus struct {
+	Ready             bool  `json:"ready"`
+	AdminReady        bool  `json:"admin_ready"`
+	Paused            bool  `json:"paused"`
+	Draining          bool  `json:"draining"`
+	ActiveConnections int64 `json:"active_connections"`
+	ZoneConfigVersion int64 `json:"zone_config_version"`
+}
+
+// newAdminServer returns the admin API, which lets test scripts change the
+// behaviour of a running server:
+//
+//	GET    /admin/status       server state as JSON
+//	GET    /admin/zone-config  active zone config as YAML
+//	PUT    /admin/zone-config  replace the zone config, until the file changes
+//	POST   /admin/pause        hold new requests until resumed
+//	DELETE /admin/pause        resume serving
+//	PUT    /admin/readiness    set readiness, the body is true or false
+//	POST   /admin/drain        report not ready and close idle connections
+//	DELETE /admin/drain        stop draining, ready unless set to false
+//
+// The server is only ready when it is not draining and readiness is not set
+// to false, so stopping a drain keeps an admin readiness of false.
+//
+// Every request needs an Authorization: Bearer <token> header.
+func (e *EchoServer) newAdminServer() (*http.Server, error) {
+	if *adminTokenFile == "" {
+		return nil, fmt.Errorf("the admin API needs --echo-server-admin-token-file")
+	}
+	data, err := os.ReadFile(*adminTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading admin token: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return nil, fmt.Errorf("admin token file %s is empty", *adminTokenFile)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/status", e.handleAdminStatus)
+	mux.HandleFunc("GET /admin/zone-config", e.handleGetZoneConfig)
+	mux.HandleFunc("PUT /admin/zone-config", e.handlePutZoneConfig)
+	mux.HandleFunc("POST /admin/pause", func(w http.ResponseWriter, r *http.Request) {
+		e.pause()
+		e.log.Info("Paused serving by admin request")
+		e.handleAdminStatus(w, r)
+	})
+	mux.HandleFunc("DELETE /admin/pause", func(w http.ResponseWriter, r *http.Request) {
+		e.resume()
+		e.log.Info("Resumed serving by admin request")
+		e.handleAdminStatus(w, r)
+	})
+	mux.HandleFunc("PUT /admin/readiness", e.handlePutReadiness)
+	mux.HandleFunc("POST /admin/drain", func(w http.ResponseWriter, r *http.Request) {
+		e.drain(true)
+		e.log.Info("Draining connections by admin request")
+		e.handleAdminStatus(w, r)
+	})
+	mux.HandleFunc("DELETE /admin/drain", func(w http.ResponseWriter, r *http.Request) {
+		e.drain(false)
+		e.log.Info("Stopped draining by admin request")
+		e.handleAdminStatus(w, r)
+	})
+
+	return &http.Server{
+		Addr:    net.JoinHostPort(*listenIP, strconv.Itoa(*adminPort)),
+		Handler: requireToken(e.log, token, mux),
+	}, nil
+}
+
+// requireToken rejects requests without the bearer token
+func requireToken(log *slog.Logger, token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			log.Warn("Rejected unauthenticated admin request", slog.String("path", r.URL.Path), slog.String("client-addr", r.RemoteAddr))
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (e *EchoServer) handleAdminStatus(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	e.readyMu.Lock()
+	adminReady, draining := !e.adminNotReady, e.draining
+	e.readyMu.Unlock()
+	json.NewEncoder(w).Encode(adminStatus{
+		Ready:             e.ready.Load(),
+		AdminReady:        adminReady,
+		Paused:            e.isPaused(),
+		Draining:          draining,
+		ActiveConnections: e.connections.Load(),
+		ZoneConfigVersion: e.zoneConfig.Version(),
+	})
+}
+
+func (e *EchoServer) handleGetZoneConfig(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Header().Set("Zone-Config-Version", strconv.FormatInt(e.zoneConfig.Version(), 10))
+	if err := yaml.NewEncoder(w).Encode(e.zoneConfig.Load()); err != nil {
+		e.log.Error("Error writing zone config", Err(err))
+	}
+}
+
+func (e *EchoServer) handlePutZoneConfig(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAdminBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cfg, err := server.ParseZoneConfig(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	old := e.zoneConfig.Base()
+	version := e.zoneConfig.Store(cfg, "admin")
+	changes := server.Diff(*old, *cfg)
+	e.log.Info("Replaced zone config by admin request", slog.Int64("version", version), slog.Int("changes", len(changes)))
+	for _, change := range changes {
+		e.log.Info("Zone config changed", slog.String("change", change))
+	}
+	e.handleAdminStatus(w, r)
+}
+
+func (e *EchoServer) handlePutReadiness(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 16))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ready, err := strconv.ParseBool(strings.TrimSpace(string(data)))
+	if err != nil {
+		http.Error(w, "body must be true or false", http.StatusBadRequest)
+		return
+	}
+	e.setAdminReady(ready)
+	e.log.Info("Set readiness by admin request", slog.Bool("ready", ready))
+	e.handleAdminStatus(w, r)
+}
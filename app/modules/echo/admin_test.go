@@ -0,0 +1,169 @@
+package echo
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Tsonov/cast-taler/app/pkg/server"
+)
+
+// newAdminTestServer returns an echo server with the zone config b: {200: 1}
+// and its admin handler, which accepts the token secret
+func newAdminTestServer(t *testing.T) (*EchoServer, http.Handler) {
+	t.Helper()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := *adminTokenFile
+	*adminTokenFile = tokenFile
+	t.Cleanup(func() { *adminTokenFile = old })
+
+	cfg, err := server.ParseZoneConfig([]byte("b: {200: 1}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ready atomic.Bool
+	e := NewEchoServer(slog.New(slog.NewTextHandler(io.Discard, nil)), "us-east1-b", "pod", "node", server.NewZoneConfigStore(cfg, "file"), &ready)
+	admin, err := e.newAdminServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, admin.Handler
+}
+
+func TestAdminToken(t *testing.T) {
+	_, handler := newAdminTestServer(t)
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "missing", wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", authorization: "Bearer guess", wantStatus: http.StatusUnauthorized},
+		{name: "token prefix", authorization: "Bearer sec", wantStatus: http.StatusUnauthorized},
+		{name: "without bearer", authorization: "secret", wantStatus: http.StatusUnauthorized},
+		{name: "correct", authorization: "Bearer secret", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/status", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestAdminPutZoneConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		// wantCode is the code of us-east1-b afterwards, the overlay keeps c
+		wantCode int
+	}{
+		{name: "valid", body: "b: {503: 1}\nc: {200: 1}\n", wantStatus: http.StatusOK, wantCode: 503},
+		{name: "invalid code", body: "b: {777: 1}\n", wantStatus: http.StatusBadRequest, wantCode: 200},
+		{name: "not yaml", body: "b: [", wantStatus: http.StatusBadRequest, wantCode: 200},
+		{name: "too large", body: "b: {200: 1}\n#" + strings.Repeat("x", maxAdminBody), wantStatus: http.StatusBadRequest, wantCode: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, handler := newAdminTestServer(t)
+			overlay, err := server.ParseZoneConfig([]byte("c: {500: 1}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.zoneConfig.SetOverlay(*overlay)
+			version := e.zoneConfig.Version()
+
+			req := httptest.NewRequest(http.MethodPut, "/admin/zone-config", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer secret")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			if changed := e.zoneConfig.Version() != version; changed != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("version changed %v, want %v", changed, tt.wantStatus == http.StatusOK)
+			}
+			cfg := e.zoneConfig.Load()
+			if code, _ := cfg.GetRandomCode("us-east1-b", ""); code != tt.wantCode {
+				t.Errorf("got code %d for us-east1-b, want %d", code, tt.wantCode)
+			}
+			if code, _ := cfg.GetRandomCode("us-east1-c", ""); code != 500 {
+				t.Errorf("got code %d for us-east1-c, want the overlay code 500", code)
+			}
+		})
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	type step struct {
+		// action is drain, undrain, ready or notready
+		action string
+		want   bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name:  "drain and stop draining",
+			steps: []step{{"drain", false}, {"undrain", true}},
+		},
+		{
+			name:  "stopping a drain keeps admin not ready",
+			steps: []step{{"notready", false}, {"drain", false}, {"undrain", false}, {"ready", true}},
+		},
+		{
+			name:  "admin ready while draining",
+			steps: []step{{"drain", false}, {"ready", false}, {"undrain", true}},
+		},
+		{
+			name:  "admin not ready after a drain",
+			steps: []step{{"drain", false}, {"undrain", true}, {"notready", false}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ready atomic.Bool
+			e := NewEchoServer(slog.New(slog.NewTextHandler(io.Discard, nil)), "zone", "pod", "node", nil, &ready)
+			e.srv = &http.Server{}
+			e.readyMu.Lock()
+			e.serving = true
+			e.updateReady()
+			e.readyMu.Unlock()
+
+			for i, s := range tt.steps {
+				switch s.action {
+				case "drain":
+					e.drain(true)
+				case "undrain":
+					e.drain(false)
+				case "ready":
+					e.setAdminReady(true)
+				case "notready":
+					e.setAdminReady(false)
+				}
+				if got := ready.Load(); got != s.want {
+					t.Fatalf("step %d %s: got ready %v, want %v", i, s.action, got, s.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package echo
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// BodyPatternRandom does not compress at all
+	BodyPatternRandom = "random"
+	// BodyPatternZeros compresses to almost nothing
+	BodyPatternZeros = "zeros"
+	// BodyPatternText repeats a block of log like text, compressing about as
+	// well as real text payloads
+	BodyPatternText = "text"
+)
+
+var (
+	clientBodyPattern = clientFlags.String("client-body-pattern", BodyPatternRandom, "content of request bodies, random, zeros or text, the last two compress well on the wire")
+	clientBodySeed    = clientFlags.Uint64("client-body-seed", 0, "seed of random request bodies, 0 picks a new seed for every request")
+)
+
+var textBlock = []byte(strings.Repeat(
+	`{"level":"info","msg":"request served","zone":"us-east1-b","status":200,"duration_ms":12}`+"\n", 64))
+
+// requestBody generates a request body of a given size without holding it in
+// memory. Every call to reader starts the same bytes again, so retries send
+// the same body.
+type requestBody struct {
+	size    int64
+	pattern string
+	seed    uint64
+}
+
+func newRequestBody(size int64, pattern string, seed uint64) requestBody {
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	return requestBody{size: size, pattern: pattern, seed: seed}
+}
+
+func validBodyPattern(pattern string) error {
+	switch pattern {
+	case BodyPatternRandom, BodyPatternZeros, BodyPatternText:
+		return nil
+	}
+	return fmt.Errorf("unknown body pattern %q", pattern)
+}
+
+func (b requestBody) reader() io.Reader {
+	var source io.Reader
+	switch b.pattern {
+	case BodyPatternZeros:
+		source = zeroReader{}
+	case BodyPatternText:
+		source = &repeatReader{block: textBlock}
+	default:
+		var seed [32]byte
+		binary.LittleEndian.PutUint64(seed[:], b.seed)
+		source = rand.NewChaCha8(seed)
+	}
+	return io.LimitReader(source, b.size)
+}
+
+// request returns a POST request sending the body. sent holds the reader of
+// the latest attempt, net/http calls GetBody to resend the body on a new
+// connection.
+func (b requestBody) request(ctx context.Context, url string, sent *atomic.Pointer[countingReader]) (*http.Request, error) {
+	sent.Store(&countingReader{r: b.reader()})
+	r, err := http.NewRequestWithContext(ctx, "POST", url, sent.Load())
+	if err != nil {
+		return nil, err
+	}
+	// a known length avoids chunked encoding
+	r.ContentLength = b.size
+	r.GetBody = func() (io.ReadCloser, error) {
+		c := &countingReader{r: b.reader()}
+		sent.Store(c)
+		return io.NopCloser(c), nil
+	}
+	return r, nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+type repeatReader struct {
+	block  []byte
+	offset int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.block[r.offset:])
+		r.offset = (r.offset + c) % len(r.block)
+		n += c
+	}
+	return n, nil
+}
@@ -0,0 +1,101 @@
+package echo
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRequestBody(t *testing.T) {
+	tests := []struct {
+		pattern string
+		size    int64
+	}{
+		{pattern: BodyPatternRandom, size: 100_000},
+		{pattern: BodyPatternZeros, size: 100_000},
+		{pattern: BodyPatternText, size: 100_000},
+		{pattern: BodyPatternRandom, size: 0},
+		{pattern: BodyPatternText, size: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+"/"+strconv.FormatInt(tt.size, 10), func(t *testing.T) {
+			body := newRequestBody(tt.size, tt.pattern, 0)
+			var sent atomic.Pointer[countingReader]
+			r, err := body.request(t.Context(), "http://localhost/echo", &sent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.ContentLength != tt.size {
+				t.Errorf("got Content-Length %d, want %d", r.ContentLength, tt.size)
+			}
+
+			first, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(first)) != tt.size || sent.Load().n.Load() != tt.size {
+				t.Errorf("got %d bytes, counted %d, want %d", len(first), sent.Load().n.Load(), tt.size)
+			}
+
+			// a retry on a new connection reads the body again through GetBody
+			again, err := r.GetBody()
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := io.ReadAll(again)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(first, second) {
+				t.Error("GetBody returned different bytes")
+			}
+			if sent.Load().n.Load() != tt.size {
+				t.Errorf("counted %d bytes of the resent body, want %d", sent.Load().n.Load(), tt.size)
+			}
+
+			third, err := io.ReadAll(body.reader())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(first, third) {
+				t.Error("reader returned different bytes")
+			}
+		})
+	}
+}
+
+func TestRequestBodyContentLength(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		if r.TransferEncoding != nil {
+			http.Error(w, "chunked", http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, r.Header.Get("Content-Length")+" "+strconv.FormatInt(n, 10))
+	}))
+	defer srv.Close()
+
+	for _, pattern := range []string{BodyPatternRandom, BodyPatternZeros, BodyPatternText} {
+		t.Run(pattern, func(t *testing.T) {
+			const size = 70_000
+			var sent atomic.Pointer[countingReader]
+			r, err := newRequestBody(size, pattern, 1).request(t.Context(), srv.URL, &sent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := srv.Client().Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			got, _ := io.ReadAll(resp.Body)
+			if want := "70000 70000"; resp.StatusCode != http.StatusOK || string(got) != want {
+				t.Errorf("got %d %q, want Content-Length and body size %q", resp.StatusCode, got, want)
+			}
+		})
+	}
+}
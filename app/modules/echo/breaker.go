@@ -0,0 +1,146 @@
+package echo
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Tsonov/cast-taler/app/pkg/metrics"
+)
+
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+// breaker is a consecutive failures circuit breaker. After threshold failures
+// in a row it rejects requests for openDuration, then lets a single probe
+// through and closes again if the probe succeeds.
+type breaker struct {
+	target       string
+	threshold    int
+	openDuration time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	// evicted breakers no longer report their state, requests in flight may
+	// still record on them
+	evicted bool
+}
+
+func newBreaker(target string, threshold int, openDuration time.Duration) *breaker {
+	b := &breaker{
+		target:       target,
+		threshold:    threshold,
+		openDuration: openDuration,
+	}
+	metrics.SetClientCircuitState(target, float64(breakerClosed))
+	return b
+}
+
+// Allow reports whether a request may be sent. Every allowed request must be
+// followed by a call to Record.
+func (b *breaker) Allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.openDuration {
+			return errCircuitOpen
+		}
+		b.setState(breakerHalfOpen)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			return errCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *breaker) Record(success bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if success {
+		b.failures = 0
+		b.setState(breakerClosed)
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+func (b *breaker) setState(state breakerState) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+	if !b.evicted {
+		metrics.SetClientCircuitState(b.target, float64(state))
+	}
+}
+
+// breakers holds one breaker per target, targets are endpoints in endpoint
+// mode and have to be evicted once they leave the Service
+type breakers struct {
+	threshold    int
+	openDuration time.Duration
+
+	mu    sync.Mutex
+	items map[string]*breaker
+}
+
+func newBreakers(threshold int, openDuration time.Duration) *breakers {
+	return &breakers{
+		threshold:    threshold,
+		openDuration: openDuration,
+		items:        map[string]*breaker{},
+	}
+}
+
+func (b *breakers) get(target string) *breaker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	br, ok := b.items[target]
+	if !ok {
+		br = newBreaker(target, b.threshold, b.openDuration)
+		b.items[target] = br
+	}
+	return br
+}
+
+// evict drops the breakers of targets that are gone and their metrics
+func (b *breakers) evict(targets ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, target := range targets {
+		br, ok := b.items[target]
+		if !ok {
+			continue
+		}
+		delete(b.items, target)
+		br.mu.Lock()
+		br.evicted = true
+		br.mu.Unlock()
+		metrics.DeleteClientCircuitState(target)
+	}
+}
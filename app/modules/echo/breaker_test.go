@@ -0,0 +1,127 @@
+package echo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	type step struct {
+		// action is allow, success, failure or wait
+		action    string
+		wantState breakerState
+		// wantOpen is whether allow is rejected
+		wantOpen bool
+	}
+	tests := []struct {
+		name      string
+		threshold int
+		steps     []step
+	}{
+		{
+			name:      "opens after threshold failures in a row",
+			threshold: 3,
+			steps: []step{
+				{action: "failure", wantState: breakerClosed},
+				{action: "failure", wantState: breakerClosed},
+				{action: "failure", wantState: breakerOpen},
+				{action: "allow", wantState: breakerOpen, wantOpen: true},
+			},
+		},
+		{
+			name:      "a success resets the failures",
+			threshold: 2,
+			steps: []step{
+				{action: "failure", wantState: breakerClosed},
+				{action: "success", wantState: breakerClosed},
+				{action: "failure", wantState: breakerClosed},
+			},
+		},
+		{
+			name:      "a successful probe closes",
+			threshold: 1,
+			steps: []step{
+				{action: "failure", wantState: breakerOpen},
+				{action: "wait", wantState: breakerOpen},
+				{action: "allow", wantState: breakerHalfOpen},
+				// only a single probe at a time
+				{action: "allow", wantState: breakerHalfOpen, wantOpen: true},
+				{action: "success", wantState: breakerClosed},
+				{action: "allow", wantState: breakerClosed},
+			},
+		},
+		{
+			name:      "a failed probe opens again",
+			threshold: 5,
+			steps: []step{
+				{action: "failure", wantState: breakerClosed},
+				{action: "failure", wantState: breakerClosed},
+				{action: "failure", wantState: breakerClosed},
+				{action: "failure", wantState: breakerClosed},
+				{action: "failure", wantState: breakerOpen},
+				{action: "wait", wantState: breakerOpen},
+				{action: "allow", wantState: breakerHalfOpen},
+				{action: "failure", wantState: breakerOpen},
+				{action: "allow", wantState: breakerOpen, wantOpen: true},
+			},
+		},
+		{
+			name:      "disabled",
+			threshold: 0,
+			steps: []step{
+				{action: "failure", wantState: breakerClosed},
+				{action: "failure", wantState: breakerClosed},
+				{action: "allow", wantState: breakerClosed},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker("test:8080", tt.threshold, time.Minute)
+			for i, s := range tt.steps {
+				var err error
+				switch s.action {
+				case "allow":
+					err = b.Allow()
+				case "success":
+					b.Record(true)
+				case "failure":
+					b.Record(false)
+				case "wait":
+					b.openedAt = b.openedAt.Add(-b.openDuration)
+				}
+				if (err != nil) != s.wantOpen {
+					t.Fatalf("step %d %s: got %v, want open %v", i, s.action, err, s.wantOpen)
+				}
+				if b.state != s.wantState {
+					t.Fatalf("step %d %s: got state %d, want %d", i, s.action, b.state, s.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakersEvict(t *testing.T) {
+	b := newBreakers(1, time.Minute)
+	gone := b.get("10.0.0.1:8080")
+	kept := b.get("10.0.0.2:8080")
+	if b.get("10.0.0.1:8080") != gone {
+		t.Fatal("got a new breaker for a known target")
+	}
+
+	b.evict("10.0.0.1:8080", "10.0.0.3:8080")
+	if _, ok := b.items["10.0.0.1:8080"]; ok {
+		t.Error("evicted breaker is still held")
+	}
+	if !gone.evicted || kept.evicted {
+		t.Error("evicted the wrong breaker")
+	}
+	if b.get("10.0.0.2:8080") != kept {
+		t.Error("lost the breaker of a remaining target")
+	}
+	// a target coming back starts with a closed breaker
+	gone.Record(false)
+	if again := b.get("10.0.0.1:8080"); again == gone || again.state != breakerClosed {
+		t.Error("a returning target did not get a new closed breaker")
+	}
+}
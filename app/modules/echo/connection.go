@@ -0,0 +1,64 @@
+package echo
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// ConnectionModeNew opens a new connection for every request, so every
+	// request is load balanced on its own
+	ConnectionModeNew = "new"
+	// ConnectionModePooled reuses idle HTTP/1.1 connections, requests stick to
+	// the pods the pool is connected to
+	ConnectionModePooled = "pooled"
+	// ConnectionModeH2C multiplexes all requests to a host over one HTTP/2
+	// cleartext connection, the stickiest mode. The server needs --echo-server-h2c.
+	ConnectionModeH2C = "h2c"
+)
+
+var (
+	clientConnectionMode = clientFlags.String("client-connection-mode", ConnectionModePooled, "connection management, new for a connection per request, pooled for keep-alive connections or h2c for HTTP/2 over cleartext")
+	clientMaxConnections = clientFlags.Int("client-max-connections", 0, "connections per target in the pooled and h2c modes, 0 is no limit")
+	clientIdleTimeout    = clientFlags.Duration("client-idle-timeout", 90*time.Second, "how long an idle pooled connection is kept open")
+)
+
+// newHTTPClient returns the client shared by all requests, its transport
+// implements the connection mode
+func newHTTPClient(mode string, maxConnections int, idleTimeout time.Duration) (*http.Client, error) {
+	if maxConnections < 0 {
+		return nil, fmt.Errorf("client max connections cannot be negative, got %d", maxConnections)
+	}
+	if idleTimeout < 0 {
+		return nil, fmt.Errorf("client idle timeout cannot be negative, got %s", idleTimeout)
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxConnsPerHost:     maxConnections,
+		MaxIdleConnsPerHost: maxConnections,
+		IdleConnTimeout:     idleTimeout,
+	}
+	if maxConnections == 0 {
+		// the default of 2 idle connections would close most connections of a
+		// busy client after every request
+		transport.MaxIdleConnsPerHost = 100
+	}
+
+	switch mode {
+	case ConnectionModeNew:
+		transport.DisableKeepAlives = true
+	case ConnectionModePooled:
+	case ConnectionModeH2C:
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	default:
+		return nil, fmt.Errorf("unknown connection mode %q", mode)
+	}
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,85 @@
+package echo
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectionModes(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}))
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetHTTP1(true)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	defer srv.Close()
+
+	tests := []struct {
+		mode       string
+		wantProto  string
+		wantReused bool
+	}{
+		{mode: ConnectionModeNew, wantProto: "HTTP/1.1", wantReused: false},
+		{mode: ConnectionModePooled, wantProto: "HTTP/1.1", wantReused: true},
+		{mode: ConnectionModeH2C, wantProto: "HTTP/2.0", wantReused: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			client, err := newHTTPClient(tt.mode, 1, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.CloseIdleConnections()
+
+			for i := range 3 {
+				var reused bool
+				trace := &httptrace.ClientTrace{
+					GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused },
+				}
+				req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(t.Context(), trace), http.MethodGet, srv.URL, nil)
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+
+				if string(body) != tt.wantProto {
+					t.Errorf("request %d used %s, want %s", i, body, tt.wantProto)
+				}
+				// the first request always opens a connection
+				if want := tt.wantReused && i > 0; reused != want {
+					t.Errorf("request %d: got reused %v, want %v", i, reused, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewHTTPClientErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		mode           string
+		maxConnections int
+		idleTimeout    time.Duration
+		wantErr        string
+	}{
+		{name: "unknown mode", mode: "sticky", wantErr: "unknown connection mode"},
+		{name: "negative max connections", mode: ConnectionModePooled, maxConnections: -1, wantErr: "max connections cannot be negative"},
+		{name: "negative idle timeout", mode: ConnectionModePooled, idleTimeout: -time.Second, wantErr: "idle timeout cannot be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newHTTPClient(tt.mode, tt.maxConnections, tt.idleTimeout)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error with %q", err, tt.wantErr)
+			}
+		})
+	}
+}
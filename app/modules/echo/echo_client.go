@@ -1,92 +1,367 @@
 package echo
 
 import (
-	"bytes"
 	"context"
-	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
-	mathrand "math/rand"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync/atomic"
 	"time"
 
-	"github.com/spf13/pflag"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/Tsonov/cast-taler/app/pkg/k8s"
+	"github.com/Tsonov/cast-taler/app/pkg/metrics"
 )
 
 var (
-	serverAddress = pflag.String("echo-server-address", "echo-server", "Address of echo server")
-	echoPort      = pflag.Int("echo-port", 8080, "Port of echo server")
-	maxDataSizeMB = pflag.Int("max-data-size-mb", 5, "Maximum data transfered per connection in MB")
-	minDataSizeMB = pflag.Int("min-data-size-mb", 1, "Minimum data transfered per connection in MB")
-)
+	serverAddress = clientFlags.String("echo-server-address", "echo-server", "Address of echo server")
+	echoPort      = clientFlags.Int("echo-port", 8080, "Port of echo server")
+	maxDataSizeMB = clientFlags.Int("max-data-size-mb", 5, "Maximum data transfered per connection in MB")
+	minDataSizeMB = clientFlags.Int("min-data-size-mb", 1, "Minimum data transfered per connection in MB")
+
+	clientRequestSizePath = clientFlags.String("client-request-size", "", "path to a request size distribution file, overrides --min-data-size-mb and --max-data-size-mb")
+
+	clientRequestNumberPerSecond = clientFlags.Int("client-request-number-per-second", 10, "number of requests per second for echo client")
+	clientLoadProfilePath        = clientFlags.String("client-load-profile", "", "path to a load profile file, overrides --client-request-number-per-second")
+	clientWorkers                = clientFlags.Int("client-workers", 4, "maximum number of requests in flight for echo client")
 
-const (
-	MB               = 1024 * 1024
-	operationTimeout = 20 * time.Second
+	clientRequestTimeout      = clientFlags.Duration("client-request-timeout", 30*time.Second, "timeout of a single attempt including reading the response, a timed out attempt counts as a transport error. 0 disables it")
+	clientMaxRetries          = clientFlags.Int("client-max-retries", 2, "retries of a request that failed with a transport error, HTTP error codes are not retried")
+	clientRetryBackoff        = clientFlags.Duration("client-retry-backoff", 100*time.Millisecond, "initial backoff between retries, doubled on every retry. 0 retries right away")
+	clientRetryMaxBackoff     = clientFlags.Duration("client-retry-max-backoff", 5*time.Second, "upper bound of the backoff between retries")
+	clientBreakerThreshold    = clientFlags.Int("client-breaker-threshold", 5, "consecutive transport errors that open the circuit breaker of a target, 0 disables it")
+	clientBreakerOpenDuration = clientFlags.Duration("client-breaker-open-duration", 10*time.Second, "how long an open circuit breaker rejects requests before probing the target again")
+	clientErrorBudget         = clientFlags.Float64("client-error-budget", 0.5, "share of failed requests in a window after which the client fails, 1 disables it")
+	clientErrorBudgetWindow   = clientFlags.Duration("client-error-budget-window", 5*time.Minute, "window the error budget is evaluated over")
+	clientErrorBudgetMin      = clientFlags.Int("client-error-budget-min-requests", 10, "requests needed in a window before the error budget is evaluated")
+
+	clientEndpointMode    = clientFlags.String("client-endpoint-mode", "", "send requests to the pods behind the server Service instead of its VIP, picking endpoints random, same-zone or by topology hints. Needs --node-name")
+	clientEndpointRefresh = clientFlags.Duration("client-endpoint-refresh-interval", 5*time.Second, "how long to wait before listing the EndpointSlices of the server Service again when their watch failed in endpoint mode")
+
+	clientResponseMode       = clientFlags.String("client-response-mode", "", "response mode requested from the server, one of echo, fixed, multiple, download or upload, empty uses the server default. Download sends an empty request")
+	clientResponseSize       = clientFlags.Int64("client-response-size", 0, "response size requested in the fixed and download modes, 0 uses the server default")
+	clientResponseMultiplier = clientFlags.Float64("client-response-multiplier", 0, "response size multiplier requested in the multiple mode, 0 uses the server default")
 )
 
+const MB = 1024 * 1024
+
 type EchoClient struct {
 	log              *slog.Logger
 	availabilityZone string
 	podName          string
+	// pods is nil outside of a cluster, target zones then only come from response headers
+	pods *k8s.PodResolver
+	// http is shared by all requests so connections can be reused, see
+	// --client-connection-mode
+	http *http.Client
+	// requestTimeout bounds every attempt, 0 is no timeout
+	requestTimeout time.Duration
+
+	retry    retryPolicy
+	breakers *breakers
+	budget   *errorBudget
 }
 
-func NewEchoClient(log *slog.Logger, availabilityZone, podName string) *EchoClient {
+func NewEchoClient(log *slog.Logger, availabilityZone, podName string, pods *k8s.PodResolver) *EchoClient {
 	return &EchoClient{
 		log:              log,
 		availabilityZone: availabilityZone,
 		podName:          podName,
+		pods:             pods,
+		requestTimeout:   *clientRequestTimeout,
+		retry: retryPolicy{
+			maxRetries:     *clientMaxRetries,
+			initialBackoff: *clientRetryBackoff,
+			maxBackoff:     *clientRetryMaxBackoff,
+		},
+		breakers: newBreakers(*clientBreakerThreshold, *clientBreakerOpenDuration),
+		budget: &errorBudget{
+			budget:      *clientErrorBudget,
+			window:      *clientErrorBudgetWindow,
+			minRequests: *clientErrorBudgetMin,
+		},
 	}
 }
 
-func (e *EchoClient) Run(ctx context.Context, requestNumberPerSecond int) error {
+// Run sends requests for every flow at the rate of its profile, using up to
+// workers requests in flight per flow. All workers of a flow share one pacer,
+// so the rate does not depend on server latency as long as there are enough
+// workers.
+func (e *EchoClient) Run(ctx context.Context, flows []*flow, workers int) error {
+	if workers <= 0 {
+		return fmt.Errorf("client workers must be positive, got %d", workers)
+	}
+	if e.requestTimeout < 0 {
+		return fmt.Errorf("client request timeout cannot be negative, got %s", e.requestTimeout)
+	}
+	if e.retry.maxRetries < 0 {
+		return fmt.Errorf("client max retries cannot be negative, got %d", e.retry.maxRetries)
+	}
+	if e.retry.initialBackoff < 0 || e.retry.maxBackoff < 0 {
+		return fmt.Errorf("client retry backoffs cannot be negative, got %s and max %s", e.retry.initialBackoff, e.retry.maxBackoff)
+	}
+	if *clientBreakerOpenDuration < 0 {
+		return fmt.Errorf("client breaker open duration cannot be negative, got %s", *clientBreakerOpenDuration)
+	}
+	if e.budget.budget < 1 && e.budget.window <= 0 {
+		return fmt.Errorf("client error budget window must be positive, got %s", e.budget.window)
+	}
+	client, err := newHTTPClient(*clientConnectionMode, *clientMaxConnections, *clientIdleTimeout)
+	if err != nil {
+		return err
+	}
+	e.http = client
+	defer client.CloseIdleConnections()
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		return e.budget.Run(groupCtx)
+	})
+	for _, f := range flows {
+		for _, d := range f.destinations {
+			if d.endpoints != nil {
+				d.endpoints.OnRemove(func(addresses []string) {
+					e.breakers.evict(addresses...)
+				})
+				group.Go(func() error {
+					d.endpoints.Run(groupCtx, *clientEndpointRefresh)
+					return nil
+				})
+			}
+		}
+		e.runFlow(groupCtx, group, f, workers)
+	}
+	return group.Wait()
+}
+
+// runFlow starts the pacer and workers of a flow in group
+func (e *EchoClient) runFlow(ctx context.Context, group *errgroup.Group, f *flow, workers int) {
+	start := time.Now()
+	pacer := newPacer(f.profile.Rate(0))
+	metrics.SetClientTargetRate(f.name, f.profile.Rate(0))
+
+	var completed atomic.Int64
+	group.Go(func() error {
+		reportAchievedRate(ctx, f.name, &completed)
+		return nil
+	})
+	group.Go(func() error {
+		followProfile(ctx, f, start, pacer)
+		return nil
+	})
+	for i := 0; i < workers; i++ {
+		group.Go(func() error {
+			for {
+				if err := pacer.Wait(ctx); err != nil {
+					return ctx.Err()
+				}
+				metrics.AddClientInFlight(1)
+				err := e.sendRequest(ctx, f.pick())
+				metrics.AddClientInFlight(-1)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				// failed requests are already logged and counted by the error budget
+				if err == nil {
+					completed.Add(1)
+				}
+			}
+		})
+	}
+}
+
+// followProfile updates the pacer with the profile rate every second
+func followProfile(ctx context.Context, f *flow, start time.Time, pacer *pacer) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
-		default:
+			return
+		case now := <-ticker.C:
+			rate := f.profile.Rate(now.Sub(start))
+			pacer.SetRate(rate)
+			metrics.SetClientTargetRate(f.name, rate)
 		}
+	}
+}
 
-		e.log.Info("Connecting to server.")
-		bufSize := mathrand.Intn(*maxDataSizeMB-*minDataSizeMB) + *minDataSizeMB
-		buff := make([]byte, bufSize*MB)
+// reportAchievedRate publishes the number of requests completed every second
+func reportAchievedRate(ctx context.Context, flow string, completed *atomic.Int64) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			metrics.SetClientAchievedRate(flow, float64(completed.Swap(0))/now.Sub(last).Seconds())
+			last = now
+		}
+	}
+}
 
-		e.log.Info("Sending data", slog.Int("buff-size", bufSize*MB))
-		rand.Read(buff)
+func (e *EchoClient) sendRequest(ctx context.Context, dest *destination) error {
+	e.log.Info("Connecting to server.", slog.String("target", dest.host))
+	size := dest.sizes.Sample()
+	if dest.responseMode == ResponseModeDownload {
+		size = 0
+	}
+	body := newRequestBody(size, dest.bodyPattern, *clientBodySeed)
 
-		url := fmt.Sprintf("http://%s:%d/echo", *serverAddress, *echoPort)
-		r, err := http.NewRequest("POST", url, bytes.NewBuffer(buff))
-		if err != nil {
-			e.log.Error("Failed to create POST request.", Err(err))
-			return fmt.Errorf("create POST request: %w", err)
+	e.log.Info("Sending data", slog.Int64("buff-size", size))
 
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(e.retry.backoff(attempt)):
+			}
+			metrics.IncClientRetries()
 		}
 
-		r.Header.Add("Content-Type", "text/plain")
-		r.Header.Add(AvailabilityZoneHeader, e.availabilityZone)
-		r.Header.Add(PodNameHeader, e.podName)
-
-		client := &http.Client{}
-		resp, err := client.Do(r)
+		// every attempt picks an endpoint again, so retries can move to another pod
+		host, err := e.resolveHost(dest)
 		if err != nil {
-			e.log.Error("Error connecting to server", Err(err))
-			return fmt.Errorf("connecting to server: %w", err)
+			metrics.TrackClientError(e.availabilityZone, "", "no_endpoints")
+			e.budget.Record(false)
+			return err
 		}
-		defer resp.Body.Close()
+		breaker := e.breakers.get(host)
 
-		e.log.Info("Receiving data")
-		data, err := io.ReadAll(resp.Body)
-		if err != nil {
-			e.log.Error("Error reading response", Err(err))
-			return fmt.Errorf("reading response: %w", err)
+		// rejected requests count against the error budget, otherwise a target
+		// that stays down would never exhaust it
+		if err := breaker.Allow(); err != nil {
+			metrics.TrackClientError(e.availabilityZone, "", "circuit_open")
+			e.budget.Record(false)
+			return err
+		}
+		err = e.attempt(ctx, dest, fmt.Sprintf("http://%s/echo", host), body)
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
+		breaker.Record(err == nil)
+		if err == nil {
+			e.budget.Record(true)
+			return nil
+		}
+		if attempt >= e.retry.maxRetries {
+			e.budget.Record(false)
+			e.log.Error("Request failed", Err(err), slog.Int("attempts", attempt+1))
+			return err
+		}
+	}
+}
 
-		e.log.Info("Received data", slog.Int("bytes", len(data)), slog.Int("status_code", resp.StatusCode))
+// attempt sends one attempt bounded by the request timeout, so a server that
+// stalls fails the attempt instead of blocking the worker
+func (e *EchoClient) attempt(ctx context.Context, dest *destination, url string, body requestBody) error {
+	if e.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.requestTimeout)
+		defer cancel()
+	}
+	return e.doRequest(ctx, dest, url, body)
+}
 
-		time.Sleep(time.Second / time.Duration(requestNumberPerSecond))
+// resolveHost returns the host:port to send the next attempt to, the
+// destination itself or one of its endpoints in endpoint mode
+func (e *EchoClient) resolveHost(dest *destination) (string, error) {
+	if dest.endpoints == nil {
+		return dest.host, nil
 	}
+	ep, ok := dest.endpoints.Pick(*clientEndpointMode, e.availabilityZone)
+	if !ok {
+		return "", fmt.Errorf("no ready endpoints for %s", dest.host)
+	}
+	return ep.Address(), nil
+}
+
+// doRequest sends one attempt and returns an error only for transport
+// failures, any HTTP status counts as a response
+func (e *EchoClient) doRequest(ctx context.Context, dest *destination, url string, body requestBody) error {
+	// sent counts what the transport actually read of the body, a server that
+	// responds early may not get all of it
+	var sent atomic.Pointer[countingReader]
+	r, err := body.request(ctx, url, &sent)
+	if err != nil {
+		e.log.Error("Failed to create POST request.", Err(err))
+		return fmt.Errorf("create POST request: %w", err)
+
+	}
+
+	r.Header.Add("Content-Type", "text/plain")
+	r.Header.Add(AvailabilityZoneHeader, e.availabilityZone)
+	r.Header.Add(PodNameHeader, e.podName)
+	if dest.responseMode != "" {
+		r.Header.Add(ResponseModeHeader, dest.responseMode)
+	}
+	if dest.responseSize > 0 {
+		r.Header.Add(ResponseSizeHeader, strconv.FormatInt(dest.responseSize, 10))
+	}
+	if dest.responseMultiplier > 0 {
+		r.Header.Add(ResponseMultiplierHeader, strconv.FormatFloat(dest.responseMultiplier, 'g', -1, 64))
+	}
+
+	// remember which address the request went to, for resolving the target zone
+	var remoteAddr net.Addr
+	r = r.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			remoteAddr = info.Conn.RemoteAddr()
+			metrics.TrackClientConnection(info.Reused)
+		},
+	}))
+
+	start := time.Now()
+	resp, err := e.http.Do(r)
+	if err != nil {
+		// a canceled client is not a failure, a timed out attempt is
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return ctx.Err()
+		}
+		target := e.resolveTarget(ctx, nil, remoteAddr)
+		metrics.TrackClientError(e.availabilityZone, target.zone, errorReason(err))
+		e.log.Warn("Error connecting to server", Err(err))
+		return fmt.Errorf("connecting to server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	e.log.Info("Receiving data")
+	target := e.resolveTarget(ctx, resp, remoteAddr)
+	// the response is only counted, holding it would need as much memory as
+	// the largest response
+	received, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		metrics.TrackClientError(e.availabilityZone, target.zone, errorReason(err))
+		e.log.Warn("Error reading response", Err(err))
+		return fmt.Errorf("reading response: %w", err)
+	}
+
+	success := resp.StatusCode == http.StatusOK
+	metrics.TrackClientRequest(e.availabilityZone, target.zone, resp.StatusCode, time.Since(start).Seconds())
+	// egress traffic from the client to the server
+	metrics.TrackClientTraffic(
+		float64(sent.Load().n.Load()), success, "http",
+		e.podName, e.availabilityZone,
+		target.zone, target.pod,
+	)
+	// egress traffic from the server to the client
+	metrics.TrackClientTraffic(
+		float64(received), success, "http",
+		target.pod, target.zone,
+		e.availabilityZone, e.podName,
+	)
 
+	e.log.Info("Received data", slog.Int64("bytes", received), slog.Int("status_code", resp.StatusCode), slog.String("server-az", target.zone))
+	return nil
 }
 
 func Err(err error) slog.Attr {
@@ -6,14 +6,16 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
+	"net"
 	"net/http"
-	"strings"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/Tsonov/cast-taler/app/pkg/metrics"
 	"github.com/Tsonov/cast-taler/app/pkg/server"
-	"github.com/spf13/pflag"
 )
 
 const (
@@ -22,19 +24,39 @@ const (
 )
 
 var (
-	listenIP  = pflag.String("echo-server-listen-ip", "0.0.0.0", "IP of echo server")
-	keepAlive = pflag.Bool("echo-server-keep-alive", false, "Keep alive connection")
+	listenIP     = serverFlags.String("echo-server-listen-ip", "0.0.0.0", "IP of echo server")
+	keepAlive    = serverFlags.Bool("echo-server-keep-alive", true, "keep connections open between requests, false closes them after every response")
+	blackholeFor = serverFlags.Duration("echo-server-blackhole-for", 2*time.Minute, "how long a blackholed request is held before its connection is dropped, zones can override it with blackhole-for. 0 holds it until the client gives up")
+	serverH2C    = serverFlags.Bool("echo-server-h2c", false, "accept HTTP/2 over cleartext next to HTTP/1.1, for clients in h2c connection mode")
+
+	responseFormat = serverFlags.String("echo-server-response-format", ResponseFormatText, "response body format, text echoes the body after a status line, json wraps it in an envelope with the server identity")
 )
 
 type EchoServer struct {
 	log              *slog.Logger
 	availabilityZone string
-	zoneConfig       *server.ZoneConfig
+	zoneConfig       *server.ZoneConfigStore
 	ready            *atomic.Bool
 	podName          string
+	nodeName         string
+	// stopping is closed on shutdown to release blackholed requests
+	stopping chan struct{}
+
+	// state changed through the admin API
+	srv         *http.Server
+	pauseMu     sync.Mutex
+	resumed     chan struct{} // non nil while paused, closed on resume
+	connections atomic.Int64
+
+	// ready is only true while serving, not draining and not set to not ready
+	// through the admin API, readyMu keeps the three in line with it
+	readyMu       sync.Mutex
+	serving       bool
+	draining      bool
+	adminNotReady bool
 }
 
-func NewEchoServer(log *slog.Logger, availabilityZone string, podName string, zoneConfig *server.ZoneConfig, ready *atomic.Bool) *EchoServer {
+func NewEchoServer(log *slog.Logger, availabilityZone string, podName string, nodeName string, zoneConfig *server.ZoneConfigStore, ready *atomic.Bool) *EchoServer {
 	logger := log.With("server-az", availabilityZone)
 	return &EchoServer{
 		log:              logger,
@@ -42,42 +64,169 @@ func NewEchoServer(log *slog.Logger, availabilityZone string, podName string, zo
 		zoneConfig:       zoneConfig,
 		ready:            ready,
 		podName:          podName,
+		nodeName:         nodeName,
+		stopping:         make(chan struct{}),
 	}
 }
 
 func (e *EchoServer) Run(ctx context.Context) error {
+	if *responseFormat != ResponseFormatText && *responseFormat != ResponseFormatJSON {
+		return fmt.Errorf("unknown response format %q", *responseFormat)
+	}
+	if !validResponseMode(*responseMode) {
+		return fmt.Errorf("unknown response mode %q", *responseMode)
+	}
+	if *responseSize < 0 {
+		return fmt.Errorf("--echo-server-response-size cannot be negative, got %d", *responseSize)
+	}
+	if *responseMultiplier < 0 || math.IsNaN(*responseMultiplier) {
+		return fmt.Errorf("--echo-server-response-multiplier cannot be negative, got %g", *responseMultiplier)
+	}
+	if *maxResponseSize < 0 {
+		return fmt.Errorf("--echo-server-max-response-size cannot be negative, got %d", *maxResponseSize)
+	}
+	if *blackholeFor < 0 {
+		return fmt.Errorf("--echo-server-blackhole-for cannot be negative, got %s", *blackholeFor)
+	}
+	if key, ok := e.zoneConfig.Load().Match(e.availabilityZone); ok {
+		e.log.Info("Matched zone config entry", slog.String("config-zone", key))
+	} else {
+		e.log.Warn("Zone config has no entry for this zone, every request will return 200")
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/echo", e.handleConnection)
 
-	srv := &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", *listenIP, *echoPort),
-		Handler: mux,
+	e.srv = &http.Server{
+		Addr:      fmt.Sprintf("%s:%d", *listenIP, *echoPort),
+		Handler:   mux,
+		ConnState: e.trackConnState,
 	}
-
-	errChan := make(chan error, 1)
-	go func() {
-		e.ready.Store(true)
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			errChan <- fmt.Errorf("starting server: %w", err)
-			return
+	e.srv.SetKeepAlivesEnabled(*keepAlive)
+	if *serverH2C {
+		e.srv.Protocols = new(http.Protocols)
+		e.srv.Protocols.SetHTTP1(true)
+		e.srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+	servers := []*http.Server{e.srv}
+	if *adminPort > 0 {
+		admin, err := e.newAdminServer()
+		if err != nil {
+			return err
 		}
-		errChan <- nil
-	}()
+		e.log.Info("Starting admin API", slog.String("address", admin.Addr))
+		servers = append(servers, admin)
+	}
+
+	errChan := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errChan <- fmt.Errorf("starting server: %w", err)
+				return
+			}
+			errChan <- nil
+		}()
+	}
+	e.readyMu.Lock()
+	e.serving = true
+	e.updateReady()
+	e.readyMu.Unlock()
 
 	select {
 	case err := <-errChan:
 		return err
 	case <-ctx.Done():
+		close(e.stopping)
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		if err := srv.Shutdown(shutdownCtx); err != nil {
-			return fmt.Errorf("shutting down server: %w", err)
+		for _, srv := range servers {
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				return fmt.Errorf("shutting down server: %w", err)
+			}
 		}
 		return nil
 	}
 }
 
+func (e *EchoServer) trackConnState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		e.connections.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		e.connections.Add(-1)
+	}
+}
+
+// pause makes new requests wait until resume is called
+func (e *EchoServer) pause() {
+	e.pauseMu.Lock()
+	defer e.pauseMu.Unlock()
+	if e.resumed == nil {
+		e.resumed = make(chan struct{})
+	}
+}
+
+func (e *EchoServer) resume() {
+	e.pauseMu.Lock()
+	defer e.pauseMu.Unlock()
+	if e.resumed != nil {
+		close(e.resumed)
+		e.resumed = nil
+	}
+}
+
+func (e *EchoServer) isPaused() bool {
+	e.pauseMu.Lock()
+	defer e.pauseMu.Unlock()
+	return e.resumed != nil
+}
+
+// waitResumed blocks while the server is paused and returns false if the
+// request should be dropped instead
+func (e *EchoServer) waitResumed(request *http.Request) bool {
+	e.pauseMu.Lock()
+	resumed := e.resumed
+	e.pauseMu.Unlock()
+	if resumed == nil {
+		return true
+	}
+	select {
+	case <-resumed:
+		return true
+	case <-request.Context().Done():
+		return false
+	case <-e.stopping:
+		return false
+	}
+}
+
+// drain reports the server as not ready and closes idle connections, open
+// requests get a Connection: close header so clients move to other pods
+func (e *EchoServer) drain(draining bool) {
+	e.readyMu.Lock()
+	defer e.readyMu.Unlock()
+	e.draining = draining
+	e.updateReady()
+	e.srv.SetKeepAlivesEnabled(*keepAlive && !draining)
+}
+
+// setAdminReady overrides the readiness, false reports not ready whether
+// draining or not, true leaves it to the drain state
+func (e *EchoServer) setAdminReady(ready bool) {
+	e.readyMu.Lock()
+	defer e.readyMu.Unlock()
+	e.adminNotReady = !ready
+	e.updateReady()
+}
+
+// updateReady must be called with readyMu held
+func (e *EchoServer) updateReady() {
+	e.ready.Store(e.serving && !e.draining && !e.adminNotReady)
+}
+
 func (e *EchoServer) handleConnection(writer http.ResponseWriter, request *http.Request) {
+	start := time.Now()
 	e.log.Info("Start echo data")
 	defer request.Body.Close()
 
@@ -95,29 +244,115 @@ func (e *EchoServer) handleConnection(writer http.ResponseWriter, request *http.
 		logger = e.log.With(slog.String("client-pod-name", clientPodName))
 	}
 
-	zoneSuffix := e.availabilityZone
-	if lastHyphen := strings.LastIndex(e.availabilityZone, "-"); lastHyphen >= 0 {
-		zoneSuffix = e.availabilityZone[lastHyphen+1:]
+	if !e.waitResumed(request) {
+		logger.Info("Dropped request while paused")
+		return
 	}
 
-	returnCode, err := e.zoneConfig.GetRandomCode(zoneSuffix)
+	payload, err := requestPayload(request.Header)
 	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	received := &countingReader{r: request.Body}
+
+	// one snapshot per request, a reload in the middle must not mix configs
+	zoneConfig := e.zoneConfig.Load()
+
+	returnCode, err := zoneConfig.GetRandomCode(e.availabilityZone, clientZone)
+	if err != nil {
+		// the config is validated on load, so this means a bug rather than a bad file
 		logger.Error("Error getting random code", Err(err))
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if delay := zoneConfig.GetLatency(e.availabilityZone, clientZone); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-request.Context().Done():
+			logger.Info("Client went away during injected latency", slog.Duration("latency", delay))
+			return
+		}
+	}
+
+	switch returnCode {
+	case server.CodeBlackhole:
+		// net/http only notices the client going away once the body is consumed
+		io.Copy(io.Discard, request.Body)
+		hold := zoneConfig.GetBlackholeFor(e.availabilityZone, clientZone)
+		if hold == 0 {
+			hold = *blackholeFor
+		}
+		// a nil channel never fires, so a hold of 0 waits for the client
+		var released <-chan time.Time
+		if hold > 0 {
+			timer := time.NewTimer(hold)
+			defer timer.Stop()
+			released = timer.C
+		}
+		select {
+		case <-request.Context().Done():
+		case <-e.stopping:
+		case <-released:
+		}
+		e.abortConnection(writer, false)
+		logger.Info("Released blackholed request", slog.Duration("held", time.Since(start)))
+		return
+	case server.CodeReset, server.CodeClose:
+		e.abortConnection(writer, returnCode == server.CodeReset)
+		logger.Info("Aborted connection", slog.String("status_code", server.CodeName(returnCode)))
 		return
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		if retryAfter := zoneConfig.GetRetryAfter(e.availabilityZone, clientZone); retryAfter > 0 {
+			writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		}
+	}
+
+	// HTTP/1 stops reading the request once the response starts, echoing needs
+	// both at the same time. HTTP/2 streams are always full duplex.
+	if request.ProtoMajor == 1 {
+		if err := http.NewResponseController(writer).EnableFullDuplex(); err != nil {
+			logger.Warn("Full duplex not supported, the echoed body may be truncated", Err(err))
+		}
+	}
+	body, err := payload.body(received)
+	if err != nil {
+		logger.Error("Error reading from connection", Err(err))
+		return
+	}
+
+	e.setIdentityHeaders(writer.Header(), start)
+	if *responseFormat == ResponseFormatJSON {
+		writer.Header().Set("Content-Type", "application/json")
 	}
 	writer.WriteHeader(returnCode)
-	fmt.Fprintf(writer, "Status code: %d\n", returnCode)
 
-	written, err := io.Copy(writer, request.Body)
+	written := &countingWriter{w: writer}
+	if *responseFormat == ResponseFormatJSON {
+		err = e.writeJSON(written, envelope{
+			StatusCode:             returnCode,
+			AvailabilityZone:       e.availabilityZone,
+			PodName:                e.podName,
+			NodeName:               e.nodeName,
+			ClientAvailabilityZone: clientZone,
+			ClientPodName:          clientPodName,
+			ProcessingTime:         time.Since(start).String(),
+			ResponseMode:           payload.mode,
+		}, body)
+	} else {
+		err = e.writeText(written, returnCode, body)
+	}
 	if err != nil {
 		logger.Error("Error reading from connection", Err(err))
 	}
+	writer.Header().Set(ProcessingTimeTotalHeader, time.Since(start).String())
+
+	bytesReceived := float64(received.n.Load())
+	bytesSent := float64(written.n)
 
-	bytesSent := float64(written) * 1000 // increase traffic we report to show nicer numbers
-	
 	// egress traffic from the client to the server
 	metrics.TrackTraffic(
-		bytesSent, true, "http",
+		bytesReceived, true, "http",
 		clientPodName, clientZone,
 		e.availabilityZone, e.podName,
 	)
@@ -128,12 +363,27 @@ func (e *EchoServer) handleConnection(writer http.ResponseWriter, request *http.
 		// do not track server egress traffic in case of zone failure simulation
 		bytesSent = 0
 	}
-	
+
 	// egress traffic from the server to the client
 	metrics.TrackTraffic(
 		bytesSent, success, "http",
 		e.podName, e.availabilityZone,
 		clientZone, clientPodName,
 	)
-	logger.Info("Done echoing data", slog.Int64("bytes", written), slog.Int("status_code", returnCode))
+	logger.Info("Done echoing data", slog.Int64("bytes-received", received.n.Load()), slog.Int64("bytes", written.n), slog.String("response-mode", payload.mode), slog.Int("status_code", returnCode))
+}
+
+// abortConnection drops the client connection without a response. With reset
+// the socket is closed with SO_LINGER 0 so the client gets a RST instead of a
+// FIN.
+func (e *EchoServer) abortConnection(writer http.ResponseWriter, reset bool) {
+	conn, _, err := http.NewResponseController(writer).Hijack()
+	if err != nil {
+		// not hijackable, e.g. HTTP/2, let net/http abort the stream instead
+		panic(http.ErrAbortHandler)
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok && reset {
+		tcpConn.SetLinger(0)
+	}
+	conn.Close()
 }
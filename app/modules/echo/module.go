@@ -0,0 +1,130 @@
+package echo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/pflag"
+
+	"github.com/Tsonov/cast-taler/app/pkg/k8s"
+	"github.com/Tsonov/cast-taler/app/pkg/loadprofile"
+	"github.com/Tsonov/cast-taler/app/pkg/module"
+	"github.com/Tsonov/cast-taler/app/pkg/sizedist"
+)
+
+var (
+	clientFlags = pflag.NewFlagSet("echo-client", pflag.ExitOnError)
+	serverFlags = pflag.NewFlagSet("echo-server", pflag.ExitOnError)
+)
+
+func init() {
+	// the port is shared, the client dials it and the server listens on it
+	serverFlags.AddFlag(clientFlags.Lookup("echo-port"))
+
+	module.Register(clientModule{})
+	module.Register(serverModule{})
+}
+
+type clientModule struct{}
+
+func (clientModule) Name() string {
+	return "echo-client"
+}
+
+func (clientModule) Description() string {
+	return "sends HTTP requests with random payloads to the echo server"
+}
+
+func (clientModule) Flags() *pflag.FlagSet {
+	return clientFlags
+}
+
+func (clientModule) Run(ctx context.Context, env module.Env) error {
+	var profile loadprofile.Profile = loadprofile.Constant(*clientRequestNumberPerSecond)
+	if *clientLoadProfilePath == "" && *clientRequestNumberPerSecond <= 0 {
+		return fmt.Errorf("request number per second must be positive, got %d", *clientRequestNumberPerSecond)
+	}
+	if *clientLoadProfilePath != "" {
+		var err error
+		profile, err = loadprofile.Load(*clientLoadProfilePath)
+		if err != nil {
+			return fmt.Errorf("loading load profile: %w", err)
+		}
+	}
+	if *minDataSizeMB < 0 || *maxDataSizeMB < *minDataSizeMB {
+		return fmt.Errorf("--max-data-size-mb %d must be at least --min-data-size-mb %d", *maxDataSizeMB, *minDataSizeMB)
+	}
+	sizes := sizedist.Uniform(int64(*minDataSizeMB)*MB, int64(*maxDataSizeMB)*MB)
+	if *clientRequestSizePath != "" {
+		var err error
+		sizes, err = sizedist.Load(*clientRequestSizePath)
+		if err != nil {
+			return fmt.Errorf("loading request size distribution: %w", err)
+		}
+	}
+	var pods *k8s.PodResolver
+	if env.K8sClient != nil {
+		pods = k8s.NewPodResolver(env.K8sClient)
+	}
+	flows := []*flow{{
+		name:         defaultFlow,
+		profile:      profile,
+		destinations: []*destination{newDestination(*serverAddress, *echoPort, sizes)},
+		totalWeight:  1,
+	}}
+	if *clientTargetsPath != "" {
+		var err error
+		flows, err = loadFlows(*clientTargetsPath, profile, sizes)
+		if err != nil {
+			return fmt.Errorf("loading targets: %w", err)
+		}
+	} else if err := flows[0].destinations[0].validate(); err != nil {
+		return err
+	}
+	if *clientEndpointMode != "" {
+		if err := watchEndpoints(ctx, env, flows); err != nil {
+			return err
+		}
+	}
+	return NewEchoClient(env.Logger, env.AvailabilityZone, env.PodName, pods).Run(ctx, flows, *clientWorkers)
+}
+
+// watchEndpoints sets up an EndpointSlice watcher for every destination, the
+// first listing happens here so a wrong Service name fails at startup
+func watchEndpoints(ctx context.Context, env module.Env, flows []*flow) error {
+	if !k8s.ValidEndpointMode(*clientEndpointMode) {
+		return fmt.Errorf("unknown endpoint mode %q", *clientEndpointMode)
+	}
+	if env.K8sClient == nil {
+		return fmt.Errorf("endpoint mode %s needs a Kubernetes client, set --node-name", *clientEndpointMode)
+	}
+	for _, f := range flows {
+		for _, d := range f.destinations {
+			d.endpoints = k8s.NewEndpointWatcher(env.Logger, env.K8sClient, d.address, d.port)
+			if err := d.endpoints.Refresh(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type serverModule struct{}
+
+func (serverModule) Name() string {
+	return "echo-server"
+}
+
+func (serverModule) Description() string {
+	return "echoes HTTP request bodies back using the zone response config"
+}
+
+func (serverModule) Flags() *pflag.FlagSet {
+	return serverFlags
+}
+
+func (serverModule) ReportsReadiness() {}
+
+func (serverModule) Run(ctx context.Context, env module.Env) error {
+	return NewEchoServer(env.Logger, env.AvailabilityZone, env.PodName, env.NodeName, env.ZoneConfig, env.Ready).Run(ctx)
+}
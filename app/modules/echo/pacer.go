@@ -0,0 +1,91 @@
+package echo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pacer hands out request slots evenly spaced at a rate that can change while
+// workers are waiting. A rate of zero pauses all workers until it changes.
+type pacer struct {
+	mu   sync.Mutex
+	rate float64
+	// next is when the next slot is due. Waiters only claim it once it is due,
+	// so a rate change never has to take back slots handed out at the old rate.
+	next time.Time
+	// changed is closed and replaced on every rate change to wake up waiters
+	changed chan struct{}
+}
+
+func newPacer(rate float64) *pacer {
+	return &pacer{
+		rate:    rate,
+		changed: make(chan struct{}),
+	}
+}
+
+func (p *pacer) SetRate(rate float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if rate == p.rate {
+		return
+	}
+	if p.rate > 0 && rate > 0 {
+		// keep the schedule, the time left until the next slot is stretched
+		// or shrunk to the new interval
+		now := time.Now()
+		if remaining := p.next.Sub(now); remaining > 0 {
+			p.next = now.Add(time.Duration(float64(remaining) * p.rate / rate))
+		}
+	} else {
+		// pausing or resuming starts a new schedule
+		p.next = time.Time{}
+	}
+	p.rate = rate
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// Wait blocks until the next slot is due
+func (p *pacer) Wait(ctx context.Context) error {
+	for {
+		p.mu.Lock()
+		rate, changed := p.rate, p.changed
+		var delay time.Duration
+		if rate > 0 {
+			now := time.Now()
+			delay = p.next.Sub(now)
+			if delay <= 0 {
+				// no catching up after an idle period, that would send a burst
+				p.next = now.Add(time.Duration(float64(time.Second) / rate))
+				p.mu.Unlock()
+				return nil
+			}
+		}
+		p.mu.Unlock()
+
+		// a nil channel never fires, so a paused pacer only wakes up on a change
+		var due <-chan time.Time
+		var timer *time.Timer
+		if rate > 0 {
+			timer = time.NewTimer(delay)
+			due = timer.C
+		}
+		// another waiter may claim the slot first, so every wake up checks again
+		select {
+		case <-ctx.Done():
+			stopTimer(timer)
+			return ctx.Err()
+		case <-due:
+		case <-changed:
+			stopTimer(timer)
+		}
+	}
+}
+
+func stopTimer(t *time.Timer) {
+	if t != nil {
+		t.Stop()
+	}
+}
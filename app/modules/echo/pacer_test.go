@@ -0,0 +1,169 @@
+package echo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countSlots runs workers against p for d, calling change every tick if set,
+// and returns the number of slots handed out
+func countSlots(t *testing.T, p *pacer, workers int, d, tick time.Duration, change func(i int)) int64 {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	var slots atomic.Int64
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p.Wait(ctx) == nil {
+				slots.Add(1)
+			}
+		}()
+	}
+	if change != nil {
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+	loop:
+		for i := 0; ; i++ {
+			select {
+			case <-ctx.Done():
+				break loop
+			case <-ticker.C:
+				change(i)
+			}
+		}
+	}
+	wg.Wait()
+	return slots.Load()
+}
+
+func TestPacerRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    float64
+		workers int
+		// change is called every 10ms, e.g. a profile that moves slightly
+		change func(p *pacer, i int)
+		min    int64
+		max    int64
+	}{
+		{
+			name:    "constant",
+			rate:    40,
+			workers: 4,
+			min:     16,
+			max:     22,
+		},
+		{
+			name:    "small changes every tick",
+			rate:    40,
+			workers: 4,
+			change: func(p *pacer, i int) {
+				p.SetRate(40 + float64(i%2)*0.01)
+			},
+			min: 16,
+			max: 22,
+		},
+		{
+			name:    "slow rate with changes every tick",
+			rate:    4,
+			workers: 4,
+			change: func(p *pacer, i int) {
+				p.SetRate(4 + float64(i%2)*0.01)
+			},
+			// the first slot is due right away
+			min: 2,
+			max: 3,
+		},
+		{
+			name:    "doubled half way",
+			rate:    20,
+			workers: 4,
+			change: func(p *pacer, i int) {
+				if i == 25 {
+					p.SetRate(40)
+				}
+			},
+			min: 12,
+			max: 18,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			p := newPacer(tt.rate)
+			var change func(int)
+			if tt.change != nil {
+				change = func(i int) { tt.change(p, i) }
+			}
+			got := countSlots(t, p, tt.workers, 500*time.Millisecond, 10*time.Millisecond, change)
+			if got < tt.min || got > tt.max {
+				t.Errorf("got %d slots, want %d-%d", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestPacerSetRateKeepsSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		newRate float64
+		// the next slot is due after about this long
+		due time.Duration
+	}{
+		{name: "slightly faster", newRate: 2.5, due: 400 * time.Millisecond},
+		{name: "twice as fast", newRate: 4, due: 250 * time.Millisecond},
+		{name: "slower", newRate: 1, due: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			p := newPacer(2)
+			if err := p.Wait(context.Background()); err != nil {
+				t.Fatalf("first slot: %v", err)
+			}
+			p.SetRate(tt.newRate)
+
+			early, cancel := context.WithTimeout(context.Background(), tt.due-80*time.Millisecond)
+			defer cancel()
+			if err := p.Wait(early); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("got a slot before %s", tt.due)
+			}
+			onTime, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+			defer cancel()
+			if err := p.Wait(onTime); err != nil {
+				t.Fatalf("no slot at %s: %v", tt.due, err)
+			}
+		})
+	}
+}
+
+func TestPacerPause(t *testing.T) {
+	p := newPacer(0)
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Wait(context.Background())
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("paused pacer handed out a slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+	p.SetRate(1)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("resumed pacer: %v", err)
+		}
+	case <-time.After(50 * time.Millisecond):
+		t.Fatal("resuming did not wake up the waiter")
+	}
+}
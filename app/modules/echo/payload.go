@@ -0,0 +1,132 @@
+package echo
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+const (
+	ResponseModeHeader       = "Response-Mode"
+	ResponseSizeHeader       = "Response-Size"
+	ResponseMultiplierHeader = "Response-Multiplier"
+
+	// ResponseModeEcho sends the request body back
+	ResponseModeEcho = "echo"
+	// ResponseModeFixed sends a fixed number of bytes whatever the request size
+	ResponseModeFixed = "fixed"
+	// ResponseModeMultiple sends the request size times a multiplier
+	ResponseModeMultiple = "multiple"
+	// ResponseModeDownload sends a fixed number of bytes, clients send an empty
+	// request in this mode
+	ResponseModeDownload = "download"
+	// ResponseModeUpload sends an empty body
+	ResponseModeUpload = "upload"
+)
+
+var (
+	responseMode       = serverFlags.String("echo-server-response-mode", ResponseModeEcho, "response payload, one of echo, fixed, multiple, download or upload, clients can override it with the Response-Mode header")
+	responseSize       = serverFlags.Int64("echo-server-response-size", 1*MB, "bytes sent in the fixed and download modes, clients can override it with the Response-Size header")
+	responseMultiplier = serverFlags.Float64("echo-server-response-multiplier", 1, "response size as a multiple of the request size in the multiple mode, clients can override it with the Response-Multiplier header")
+	maxResponseSize    = serverFlags.Int64("echo-server-max-response-size", 1024*MB, "upper bound of a generated response")
+)
+
+// filler is repeated to generate responses, random so compression does not
+// shrink them
+var filler = func() []byte {
+	b := make([]byte, 64*1024)
+	rand.Read(b)
+	return b
+}()
+
+type fillReader struct {
+	offset int
+}
+
+func (f *fillReader) Read(p []byte) (int, error) {
+	n := copy(p, filler[f.offset:])
+	f.offset = (f.offset + n) % len(filler)
+	return n, nil
+}
+
+// payload describes the response body of one request
+type payload struct {
+	mode       string
+	size       int64
+	multiplier float64
+}
+
+func validResponseMode(mode string) bool {
+	switch mode {
+	case ResponseModeEcho, ResponseModeFixed, ResponseModeMultiple, ResponseModeDownload, ResponseModeUpload:
+		return true
+	}
+	return false
+}
+
+// requestPayload returns the server defaults overridden by the request headers
+func requestPayload(header http.Header) (payload, error) {
+	p := payload{mode: *responseMode, size: *responseSize, multiplier: *responseMultiplier}
+	if v := header.Get(ResponseModeHeader); v != "" {
+		if !validResponseMode(v) {
+			return p, fmt.Errorf("unknown %s %q", ResponseModeHeader, v)
+		}
+		p.mode = v
+	}
+	if v := header.Get(ResponseSizeHeader); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || size < 0 {
+			return p, fmt.Errorf("invalid %s %q", ResponseSizeHeader, v)
+		}
+		p.size = size
+	}
+	if v := header.Get(ResponseMultiplierHeader); v != "" {
+		multiplier, err := strconv.ParseFloat(v, 64)
+		if err != nil || multiplier < 0 {
+			return p, fmt.Errorf("invalid %s %q", ResponseMultiplierHeader, v)
+		}
+		p.multiplier = multiplier
+	}
+	return p, nil
+}
+
+// body returns the response body for a request. Every mode except echo reads
+// the whole request first, so its size is known and the request is counted
+// even when it is not sent back.
+func (p payload) body(request io.Reader) (io.Reader, error) {
+	if p.mode == ResponseModeEcho {
+		// an empty read makes net/http answer Expect: 100-continue before the
+		// response starts, later the client would never send the body
+		request.Read(nil)
+		return request, nil
+	}
+	received, err := io.Copy(io.Discard, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var size int64
+	switch p.mode {
+	case ResponseModeFixed, ResponseModeDownload:
+		size = p.size
+	case ResponseModeMultiple:
+		size = int64(float64(received) * p.multiplier)
+	}
+	return io.LimitReader(&fillReader{}, min(size, *maxResponseSize)), nil
+}
+
+// countingReader counts the bytes read from a body. The count is atomic, on
+// the client the transport reads the request body in its own goroutine.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,102 @@
+package echo
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRequestPayload(t *testing.T) {
+	defaults := payload{mode: *responseMode, size: *responseSize, multiplier: *responseMultiplier}
+	tests := []struct {
+		name    string
+		header  map[string]string
+		want    payload
+		wantErr string
+	}{
+		{name: "defaults", want: defaults},
+		{
+			name:   "mode",
+			header: map[string]string{ResponseModeHeader: ResponseModeFixed},
+			want:   payload{mode: ResponseModeFixed, size: defaults.size, multiplier: defaults.multiplier},
+		},
+		{
+			name:   "size and multiplier",
+			header: map[string]string{ResponseSizeHeader: "42", ResponseMultiplierHeader: "2.5"},
+			want:   payload{mode: defaults.mode, size: 42, multiplier: 2.5},
+		},
+		{
+			name:   "zero size",
+			header: map[string]string{ResponseSizeHeader: "0"},
+			want:   payload{mode: defaults.mode, size: 0, multiplier: defaults.multiplier},
+		},
+		{name: "unknown mode", header: map[string]string{ResponseModeHeader: "mirror"}, wantErr: "unknown Response-Mode"},
+		{name: "negative size", header: map[string]string{ResponseSizeHeader: "-1"}, wantErr: "invalid Response-Size"},
+		{name: "size not a number", header: map[string]string{ResponseSizeHeader: "1MB"}, wantErr: "invalid Response-Size"},
+		{name: "negative multiplier", header: map[string]string{ResponseMultiplierHeader: "-0.5"}, wantErr: "invalid Response-Multiplier"},
+		{name: "multiplier not a number", header: map[string]string{ResponseMultiplierHeader: "twice"}, wantErr: "invalid Response-Multiplier"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for k, v := range tt.header {
+				header.Set(k, v)
+			}
+			got, err := requestPayload(header)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error with %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPayloadBody(t *testing.T) {
+	old := *maxResponseSize
+	*maxResponseSize = 1000
+	t.Cleanup(func() { *maxResponseSize = old })
+
+	request := []byte(strings.Repeat("x", 100))
+	tests := []struct {
+		name     string
+		payload  payload
+		wantSize int
+	}{
+		{name: "echo", payload: payload{mode: ResponseModeEcho, size: 10}, wantSize: 100},
+		{name: "fixed", payload: payload{mode: ResponseModeFixed, size: 10}, wantSize: 10},
+		{name: "download", payload: payload{mode: ResponseModeDownload, size: 500}, wantSize: 500},
+		{name: "fixed above the max", payload: payload{mode: ResponseModeFixed, size: 5000}, wantSize: 1000},
+		{name: "multiple", payload: payload{mode: ResponseModeMultiple, multiplier: 2.5}, wantSize: 250},
+		{name: "multiple below one", payload: payload{mode: ResponseModeMultiple, multiplier: 0.5}, wantSize: 50},
+		{name: "multiple above the max", payload: payload{mode: ResponseModeMultiple, multiplier: 20}, wantSize: 1000},
+		{name: "upload", payload: payload{mode: ResponseModeUpload, size: 10}, wantSize: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := tt.payload.body(bytes.NewReader(request))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.wantSize {
+				t.Errorf("got %d bytes, want %d", len(got), tt.wantSize)
+			}
+			if tt.payload.mode == ResponseModeEcho && !bytes.Equal(got, request) {
+				t.Error("echo did not send the request back")
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package echo
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// retryPolicy retries transport errors with exponential backoff and full jitter
+type retryPolicy struct {
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// backoff returns how long to wait before the given retry, starting at 1. An
+// initial backoff of 0 retries right away.
+func (p retryPolicy) backoff(retry int) time.Duration {
+	if p.initialBackoff <= 0 {
+		return 0
+	}
+	d := p.initialBackoff << (retry - 1)
+	if d <= 0 || d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// errorBudget fails the client once the share of failed requests in a window
+// is above the budget. It is evaluated at the end of every window, so a short
+// outage like a rolling restart is absorbed by the rest of the window.
+type errorBudget struct {
+	budget      float64
+	window      time.Duration
+	minRequests int
+
+	mu       sync.Mutex
+	requests int
+	failures int
+}
+
+func (b *errorBudget) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests++
+	if !success {
+		b.failures++
+	}
+}
+
+// Run returns an error when a window exceeds the budget. A budget of 1 or more
+// never fails.
+func (b *errorBudget) Run(ctx context.Context) error {
+	if b.budget >= 1 {
+		<-ctx.Done()
+		return nil
+	}
+	ticker := time.NewTicker(b.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		b.mu.Lock()
+		requests, failures := b.requests, b.failures
+		b.requests, b.failures = 0, 0
+		b.mu.Unlock()
+
+		if requests < b.minRequests {
+			continue
+		}
+		if ratio := float64(failures) / float64(requests); ratio > b.budget {
+			return fmt.Errorf("error budget exhausted: %d of %d requests failed in the last %s, budget is %.0f%%", failures, requests, b.window, b.budget*100)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package echo
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := retryPolicy{maxRetries: 100, initialBackoff: 100 * time.Millisecond, maxBackoff: 2 * time.Second}
+	tests := []struct {
+		name   string
+		policy retryPolicy
+		retry  int
+		// samples must be in [0, max]
+		max time.Duration
+	}{
+		{name: "first retry", policy: policy, retry: 1, max: 100 * time.Millisecond},
+		{name: "doubled", policy: policy, retry: 2, max: 200 * time.Millisecond},
+		{name: "doubled twice more", policy: policy, retry: 4, max: 800 * time.Millisecond},
+		{name: "below max", policy: policy, retry: 5, max: 1600 * time.Millisecond},
+		{name: "capped", policy: policy, retry: 6, max: 2 * time.Second},
+		// the shift overflows to zero or negative values
+		{name: "overflow", policy: policy, retry: 40, max: 2 * time.Second},
+		{name: "shift by 63", policy: policy, retry: 64, max: 2 * time.Second},
+		{name: "shift beyond 63", policy: policy, retry: 100, max: 2 * time.Second},
+		{name: "no initial backoff", policy: retryPolicy{maxBackoff: 2 * time.Second}, retry: 3, max: 0},
+		{name: "no max backoff", policy: retryPolicy{initialBackoff: time.Second}, retry: 3, max: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var largest time.Duration
+			for range 2000 {
+				d := tt.policy.backoff(tt.retry)
+				if d < 0 || d > tt.max {
+					t.Fatalf("backoff %s is outside of [0, %s]", d, tt.max)
+				}
+				largest = max(largest, d)
+			}
+			// full jitter spreads over the whole range
+			if largest < tt.max/2 {
+				t.Errorf("largest backoff %s of 2000 is below half of %s", largest, tt.max)
+			}
+		})
+	}
+}
+
+func TestErrorBudget(t *testing.T) {
+	tests := []struct {
+		name                string
+		budget              float64
+		minRequests         int
+		successes, failures int
+		wantErr             bool
+	}{
+		{name: "within budget", budget: 0.5, successes: 6, failures: 4},
+		{name: "over budget", budget: 0.5, successes: 4, failures: 6, wantErr: true},
+		{name: "too few requests", budget: 0.1, minRequests: 20, failures: 10},
+		{name: "budget of 1 never fails", budget: 1, failures: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &errorBudget{budget: tt.budget, window: 20 * time.Millisecond, minRequests: tt.minRequests}
+			for range tt.successes {
+				b.Record(true)
+			}
+			for range tt.failures {
+				b.Record(false)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			err := b.Run(ctx)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "error budget exhausted") {
+					t.Errorf("got %v, want the budget to be exhausted", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
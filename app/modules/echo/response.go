@@ -0,0 +1,91 @@
+package echo
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	NodeNameHeader            = "Node-Name"
+	ProcessingTimeHeader      = "Processing-Time"
+	ProcessingTimeTotalHeader = "Processing-Time-Total"
+
+	ResponseFormatText = "text"
+	ResponseFormatJSON = "json"
+)
+
+// envelope is the JSON response format. The response payload is appended as
+// a base64 "body" field followed by "body_bytes", both streamed after these
+// fields.
+type envelope struct {
+	StatusCode             int    `json:"status_code"`
+	AvailabilityZone       string `json:"availability_zone"`
+	PodName                string `json:"pod_name"`
+	NodeName               string `json:"node_name"`
+	ClientAvailabilityZone string `json:"client_availability_zone"`
+	ClientPodName          string `json:"client_pod_name"`
+	ProcessingTime         string `json:"processing_time"`
+	ResponseMode           string `json:"response_mode"`
+}
+
+// setIdentityHeaders attributes the response to this pod. Processing time
+// covers everything before the response starts, the total including the echo
+// itself is sent as a trailer.
+func (e *EchoServer) setIdentityHeaders(header http.Header, start time.Time) {
+	header.Set(AvailabilityZoneHeader, e.availabilityZone)
+	header.Set(PodNameHeader, e.podName)
+	header.Set(NodeNameHeader, e.nodeName)
+	header.Set(ProcessingTimeHeader, time.Since(start).String())
+	header.Set("Trailer", ProcessingTimeTotalHeader)
+}
+
+func (e *EchoServer) writeText(w io.Writer, returnCode int, body io.Reader) error {
+	if _, err := fmt.Fprintf(w, "Status code: %d\n", returnCode); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, body)
+	return err
+}
+
+// writeJSON streams the envelope
+func (e *EchoServer) writeJSON(w io.Writer, env envelope, body io.Reader) error {
+	head, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	head = bytes.TrimSuffix(head, []byte("}"))
+	if _, err := w.Write(append(head, `,"body":"`...)); err != nil {
+		return err
+	}
+
+	encoder := base64.NewEncoder(base64.StdEncoding, w)
+	written, err := io.Copy(encoder, body)
+	if closeErr := encoder.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, `","body_bytes":`+strconv.FormatInt(written, 10)+"}")
+	return err
+}
+
+// countingWriter counts the bytes written to the response, so traffic
+// includes the status line, the JSON envelope and the base64 overhead
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,59 @@
+package echo
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestWriteJSON(t *testing.T) {
+	env := envelope{
+		StatusCode:             503,
+		AvailabilityZone:       "us-east1-b",
+		PodName:                "server",
+		NodeName:               "node",
+		ClientAvailabilityZone: "us-east1-c",
+		ClientPodName:          "client",
+		ProcessingTime:         "1ms",
+		ResponseMode:           ResponseModeEcho,
+	}
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "empty", body: ""},
+		{name: "text", body: "hello"},
+		{name: "needs padding", body: "ab"},
+		{name: "larger than the base64 buffer", body: strings.Repeat("0123456789", 1000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			e := &EchoServer{}
+			if err := e.writeJSON(&out, env, strings.NewReader(tt.body)); err != nil {
+				t.Fatal(err)
+			}
+
+			var got struct {
+				envelope
+				Body      []byte `json:"body"`
+				BodyBytes int    `json:"body_bytes"`
+			}
+			decoder := json.NewDecoder(&out)
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&got); err != nil {
+				t.Fatalf("invalid JSON %q: %v", out.String(), err)
+			}
+			if got.envelope != env {
+				t.Errorf("got envelope %+v, want %+v", got.envelope, env)
+			}
+			if string(got.Body) != tt.body {
+				t.Errorf("got body %q, want %q", got.Body, tt.body)
+			}
+			if got.BodyBytes != len(tt.body) {
+				t.Errorf("got body_bytes %d, want %d", got.BodyBytes, len(tt.body))
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package echo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+)
+
+// target is the server pod that handled a request
+type target struct {
+	zone string
+	pod  string
+}
+
+// resolveTarget prefers the identity the server reports in its response
+// headers and falls back to looking up the pod behind the remote IP. The
+// lookup only helps when the client talks to pod IPs directly, through a
+// Service VIP or a mesh proxy the remote IP is not the server pod.
+func (e *EchoClient) resolveTarget(ctx context.Context, resp *http.Response, remoteAddr net.Addr) target {
+	if resp != nil {
+		if zone := resp.Header.Get(AvailabilityZoneHeader); zone != "" {
+			return target{zone: zone, pod: resp.Header.Get(PodNameHeader)}
+		}
+	}
+	if e.pods == nil || remoteAddr == nil {
+		return target{}
+	}
+	host, _, err := net.SplitHostPort(remoteAddr.String())
+	if err != nil {
+		return target{}
+	}
+	info, err := e.pods.Resolve(ctx, host)
+	if err != nil {
+		e.log.Debug("Could not resolve server pod", slog.String("ip", host), Err(err))
+		return target{}
+	}
+	return target{zone: info.Zone, pod: info.Name}
+}
+
+// errorReason classifies transport errors into a small set of metric labels
+func errorReason(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return "connection_reset"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	default:
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return "dns"
+		}
+		return "other"
+	}
+}
@@ -0,0 +1,225 @@
+package echo
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/Tsonov/cast-taler/app/pkg/k8s"
+	"github.com/Tsonov/cast-taler/app/pkg/loadprofile"
+	"github.com/Tsonov/cast-taler/app/pkg/sizedist"
+)
+
+var clientTargetsPath = clientFlags.String("client-targets", "", "path to a file listing weighted targets, overrides --echo-server-address")
+
+// defaultFlow is the flow of targets that share the client rate
+const defaultFlow = "default"
+
+// TargetsConfig is the file format of --client-targets, e.g. a service that
+// mostly calls one downstream and downloads from a second one at its own rate:
+//
+//	targets:
+//	  - address: orders
+//	    weight: 3
+//	  - address: inventory
+//	    weight: 1
+//	    request-size:
+//	      type: lognormal
+//	      median: 8KiB
+//	      sigma: 1.5
+//	  - address: blobs
+//	    response-mode: download
+//	    response-size: 4MiB
+//	    load-profile:
+//	      type: constant
+//	      rate: 2
+//
+// Targets without a load profile share the client rate and are picked by
+// weight. Unset settings fall back to the client flags.
+type TargetsConfig struct {
+	Targets []TargetConfig `yaml:"targets"`
+}
+
+type TargetConfig struct {
+	Address string `yaml:"address"`
+	Port    int    `yaml:"port"`
+	// Weight defaults to 1, it only matters for targets sharing the client rate
+	Weight *int `yaml:"weight"`
+
+	RequestSize        *sizedist.Config    `yaml:"request-size"`
+	BodyPattern        string              `yaml:"body-pattern"`
+	ResponseMode       string              `yaml:"response-mode"`
+	ResponseSize       sizedist.ByteSize   `yaml:"response-size"`
+	ResponseMultiplier float64             `yaml:"response-multiplier"`
+	LoadProfile        *loadprofile.Config `yaml:"load-profile"`
+
+	// line is where the target starts in the file
+	line int
+}
+
+func (t *TargetConfig) UnmarshalYAML(node *yaml.Node) error {
+	type plain TargetConfig
+	if err := node.Decode((*plain)(t)); err != nil {
+		return err
+	}
+	t.line = node.Line
+	return nil
+}
+
+// destination is a server the client sends requests to
+type destination struct {
+	address string
+	port    int
+	host    string
+	weight  int
+	// endpoints is set in endpoint mode, requests then go to the pods behind
+	// the Service instead of its VIP
+	endpoints *k8s.EndpointWatcher
+
+	sizes              sizedist.Distribution
+	bodyPattern        string
+	responseMode       string
+	responseSize       int64
+	responseMultiplier float64
+}
+
+// flow is a set of destinations sharing one request rate
+type flow struct {
+	name         string
+	profile      loadprofile.Profile
+	destinations []*destination
+	totalWeight  int
+}
+
+// pick returns a destination with a chance proportional to its weight
+func (f *flow) pick() *destination {
+	n := rand.IntN(f.totalWeight)
+	for _, d := range f.destinations {
+		if n < d.weight {
+			return d
+		}
+		n -= d.weight
+	}
+	return f.destinations[len(f.destinations)-1]
+}
+
+// newDestination uses the client flags for everything a target does not set
+func newDestination(address string, port int, sizes sizedist.Distribution) *destination {
+	host := net.JoinHostPort(address, strconv.Itoa(port))
+	return &destination{
+		address:            address,
+		port:               port,
+		host:               host,
+		weight:             1,
+		sizes:              sizes,
+		bodyPattern:        *clientBodyPattern,
+		responseMode:       *clientResponseMode,
+		responseSize:       *clientResponseSize,
+		responseMultiplier: *clientResponseMultiplier,
+	}
+}
+
+func (d *destination) validate() error {
+	if d.responseMode != "" && !validResponseMode(d.responseMode) {
+		return fmt.Errorf("unknown response mode %q", d.responseMode)
+	}
+	return validBodyPattern(d.bodyPattern)
+}
+
+// loadFlows reads a targets file. Targets without their own load profile
+// share profile in the default flow, every other target gets a flow named
+// after its address.
+func loadFlows(path string, profile loadprofile.Profile, sizes sizedist.Distribution) ([]*flow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg TargetsConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing targets %s: %w", path, err)
+	}
+	if len(cfg.Targets) == 0 {
+		return nil, fmt.Errorf("%s: no targets", path)
+	}
+
+	shared := &flow{name: defaultFlow, profile: profile}
+	flows := []*flow{}
+	// flows are told apart by name in logs and metrics
+	flowLines := map[string]int{}
+	for i, t := range cfg.Targets {
+		d, err := t.build(filepath.Dir(path), sizes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: target %d: %w", path, i, err)
+		}
+		if t.LoadProfile == nil {
+			shared.destinations = append(shared.destinations, d)
+			shared.totalWeight += d.weight
+			continue
+		}
+		if t.LoadProfile.File != "" && !filepath.IsAbs(t.LoadProfile.File) {
+			t.LoadProfile.File = filepath.Join(filepath.Dir(path), t.LoadProfile.File)
+		}
+		own, err := t.LoadProfile.Build()
+		if err != nil {
+			return nil, fmt.Errorf("%s: target %d: load profile: %w", path, i, err)
+		}
+		if line, ok := flowLines[d.host]; ok {
+			return nil, fmt.Errorf("%s:%d: target %s already has its own load profile on line %d, merge them into one target", path, t.line, d.host, line)
+		}
+		flowLines[d.host] = t.line
+		flows = append(flows, &flow{name: d.host, profile: own, destinations: []*destination{d}, totalWeight: 1})
+	}
+	if len(shared.destinations) > 0 {
+		if shared.totalWeight == 0 {
+			return nil, fmt.Errorf("%s: weights of the targets sharing the client rate sum to 0", path)
+		}
+		flows = append([]*flow{shared}, flows...)
+	}
+	return flows, nil
+}
+
+func (t TargetConfig) build(dir string, sizes sizedist.Distribution) (*destination, error) {
+	if t.Address == "" {
+		return nil, fmt.Errorf("address is required")
+	}
+	port := t.Port
+	if port == 0 {
+		port = *echoPort
+	}
+	if t.RequestSize != nil {
+		if t.RequestSize.File != "" && !filepath.IsAbs(t.RequestSize.File) {
+			t.RequestSize.File = filepath.Join(dir, t.RequestSize.File)
+		}
+		var err error
+		sizes, err = t.RequestSize.Build()
+		if err != nil {
+			return nil, fmt.Errorf("request size: %w", err)
+		}
+	}
+
+	d := newDestination(t.Address, port, sizes)
+	if t.Weight != nil && t.LoadProfile == nil {
+		if *t.Weight < 0 {
+			return nil, fmt.Errorf("weight cannot be negative")
+		}
+		d.weight = *t.Weight
+	}
+	if t.BodyPattern != "" {
+		d.bodyPattern = t.BodyPattern
+	}
+	if t.ResponseMode != "" {
+		d.responseMode = t.ResponseMode
+	}
+	if t.ResponseSize > 0 {
+		d.responseSize = int64(t.ResponseSize)
+	}
+	if t.ResponseMultiplier > 0 {
+		d.responseMultiplier = t.ResponseMultiplier
+	}
+	return d, d.validate()
+}
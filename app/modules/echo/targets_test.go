@@ -0,0 +1,92 @@
+package echo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Tsonov/cast-taler/app/pkg/loadprofile"
+	"github.com/Tsonov/cast-taler/app/pkg/sizedist"
+)
+
+func TestLoadFlows(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets string
+		// wantFlows maps the flow names to their number of destinations
+		wantFlows map[string]int
+		wantErr   string
+	}{
+		{
+			name:      "shared rate",
+			targets:   "targets:\n  - address: a\n  - address: b\n    weight: 3\n",
+			wantFlows: map[string]int{defaultFlow: 2},
+		},
+		{
+			name: "own load profiles",
+			targets: `targets:
+  - address: a
+  - address: b
+    load-profile: {type: constant, rate: 2}
+  - address: b
+    port: 8081
+    load-profile: {type: constant, rate: 3}
+`,
+			wantFlows: map[string]int{defaultFlow: 1, "b:8080": 1, "b:8081": 1},
+		},
+		{
+			name: "duplicate flow",
+			targets: `targets:
+  - address: a
+    load-profile: {type: constant, rate: 2}
+  # same target again
+  - address: a
+    port: 8080
+    load-profile: {type: constant, rate: 3}
+`,
+			wantErr: ":5: target a:8080 already has its own load profile on line 2",
+		},
+		{
+			name:    "no targets",
+			targets: "targets: []\n",
+			wantErr: "no targets",
+		},
+		{
+			name:    "missing address",
+			targets: "targets:\n  - port: 80\n",
+			wantErr: "target 0: address is required",
+		},
+		{
+			name:    "zero weights",
+			targets: "targets:\n  - address: a\n    weight: 0\n",
+			wantErr: "sum to 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "targets.yaml")
+			if err := os.WriteFile(path, []byte(tt.targets), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			flows, err := loadFlows(path, loadprofile.Constant(1), sizedist.Fixed(1))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error with %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(flows) != len(tt.wantFlows) {
+				t.Fatalf("got %d flows, want %v", len(flows), tt.wantFlows)
+			}
+			for _, f := range flows {
+				if n, ok := tt.wantFlows[f.name]; !ok || n != len(f.destinations) {
+					t.Errorf("got flow %s with %d destinations, want %v", f.name, len(f.destinations), tt.wantFlows)
+				}
+			}
+		})
+	}
+}
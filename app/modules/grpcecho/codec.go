@@ -0,0 +1,40 @@
+package grpcecho
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// codecName is sent as the content-subtype so the server picks rawCodec
+// instead of protobuf. Echo messages are opaque payloads, so there is no
+// need for generated protobuf types.
+const codecName = "raw"
+
+func init() {
+	encoding.RegisterCodec(rawCodec{})
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("raw codec cannot marshal %T", v)
+	}
+	return *b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("raw codec cannot unmarshal into %T", v)
+	}
+	// data is owned by grpc and may be reused after Unmarshal returns
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return codecName
+}
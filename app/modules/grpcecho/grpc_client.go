@@ -0,0 +1,176 @@
+package grpcecho
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/Tsonov/cast-taler/app/modules/echo"
+)
+
+const (
+	ModeUnary  = "unary"
+	ModeStream = "stream"
+)
+
+type EchoClient struct {
+	log              *slog.Logger
+	availabilityZone string
+	podName          string
+}
+
+func NewEchoClient(log *slog.Logger, availabilityZone, podName string) *EchoClient {
+	return &EchoClient{
+		log:              log,
+		availabilityZone: availabilityZone,
+		podName:          podName,
+	}
+}
+
+func (e *EchoClient) Run(ctx context.Context, mode string, messagesPerSecond, messageSize int) error {
+	if messagesPerSecond <= 0 || messagesPerSecond > int(time.Second) {
+		return fmt.Errorf("grpc messages per second must be between 1 and %d, got %d", int(time.Second), messagesPerSecond)
+	}
+	if mode != ModeUnary && mode != ModeStream {
+		return fmt.Errorf("unknown grpc mode %q", mode)
+	}
+	if messageSize < 0 {
+		return fmt.Errorf("grpc message size cannot be negative, got %d", messageSize)
+	}
+	if mode == ModeStream && *streamLifetime <= 0 {
+		return fmt.Errorf("grpc stream lifetime must be positive, got %s", *streamLifetime)
+	}
+
+	// a single connection is kept for the lifetime of the client, like a
+	// typical service does, so all calls are multiplexed over one HTTP/2 connection
+	target := net.JoinHostPort(*serverAddress, strconv.Itoa(*grpcPort))
+	conn, err := grpc.NewClient(target,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(codecName)),
+	)
+	if err != nil {
+		return fmt.Errorf("creating grpc client for %s: %w", target, err)
+	}
+	defer conn.Close()
+
+	msg := make([]byte, messageSize)
+	rand.Read(msg)
+
+	ctx = metadata.NewOutgoingContext(ctx, identityMetadata(e.availabilityZone, e.podName))
+	interval := time.Second / time.Duration(messagesPerSecond)
+
+	for {
+		if mode == ModeUnary {
+			err = e.runUnary(ctx, conn, msg, interval)
+		} else {
+			err = e.runStream(ctx, conn, msg, interval)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			// stream reached its lifetime, open the next one right away
+			continue
+		}
+		e.log.Error("gRPC echo failed, retrying", echo.Err(err))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(*retryDelay):
+		}
+	}
+}
+
+func (e *EchoClient) runUnary(ctx context.Context, conn *grpc.ClientConn, msg []byte, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var reply []byte
+	for {
+		var header metadata.MD
+		if err := conn.Invoke(ctx, echoMethod, &msg, &reply, grpc.Header(&header)); err != nil {
+			return fmt.Errorf("unary echo: %w", err)
+		}
+		serverZone, serverPod := identityFromMetadata(header)
+		e.log.Info("Received data", slog.Int("bytes", len(reply)), slog.String("server-az", serverZone), slog.String("server-pod", serverPod))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// runStream keeps one bidirectional stream open for --grpc-stream-lifetime and
+// sends one message per tick over it. Once the lifetime is reached the client
+// half-closes the stream and reads the remaining replies, so the server sees a
+// regular end of the stream instead of a cancellation.
+func (e *EchoClient) runStream(ctx context.Context, conn *grpc.ClientConn, msg []byte, interval time.Duration) error {
+	// cancels the stream when it fails, after a graceful close it is a no-op
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := conn.NewStream(streamCtx, &serviceDesc.Streams[0], echoStreamMethod)
+	if err != nil {
+		return fmt.Errorf("opening stream: %w", err)
+	}
+	header, err := stream.Header()
+	if err != nil {
+		return fmt.Errorf("reading stream header: %w", err)
+	}
+	serverZone, serverPod := identityFromMetadata(header)
+	logger := e.log.With(slog.String("server-az", serverZone), slog.String("server-pod", serverPod))
+	logger.Info("Stream opened")
+
+	lifetime := time.NewTimer(*streamLifetime)
+	defer lifetime.Stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var reply []byte
+	var total int64
+	for {
+		if err := stream.SendMsg(&msg); err != nil {
+			return fmt.Errorf("sending on stream: %w", err)
+		}
+		if err := stream.RecvMsg(&reply); err != nil {
+			return fmt.Errorf("receiving on stream: %w", err)
+		}
+		total += int64(len(reply))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-lifetime.C:
+			// lifetime reached, close our side and let Run open a new stream
+			if err := stream.CloseSend(); err != nil {
+				return fmt.Errorf("closing stream: %w", err)
+			}
+			for {
+				err := stream.RecvMsg(&reply)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					return fmt.Errorf("receiving on closed stream: %w", err)
+				}
+				total += int64(len(reply))
+			}
+			logger.Info("Stream closed", slog.Int64("bytes", total))
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package grpcecho
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/Tsonov/cast-taler/app/pkg/metrics"
+)
+
+type EchoServer struct {
+	log              *slog.Logger
+	availabilityZone string
+	podName          string
+}
+
+func NewEchoServer(log *slog.Logger, availabilityZone, podName string) *EchoServer {
+	return &EchoServer{
+		log:              log.With("server-az", availabilityZone),
+		availabilityZone: availabilityZone,
+		podName:          podName,
+	}
+}
+
+func (e *EchoServer) Run(ctx context.Context) error {
+	addr := net.JoinHostPort(*listenIP, strconv.Itoa(*grpcPort))
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", addr, err)
+	}
+
+	srv := grpc.NewServer()
+	srv.RegisterService(&serviceDesc, e)
+
+	errChan := make(chan error, 1)
+	go func() {
+		e.log.Info("gRPC echo server listening", slog.String("address", addr))
+		if err := srv.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			errChan <- fmt.Errorf("serving grpc: %w", err)
+			return
+		}
+		errChan <- nil
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		// streams are long lived, GracefulStop would wait for clients to hang up
+		srv.Stop()
+		return nil
+	}
+}
+
+func (e *EchoServer) Echo(ctx context.Context, msg *[]byte) (*[]byte, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	clientZone, clientPodName := identityFromMetadata(md)
+
+	if err := grpc.SetHeader(ctx, identityMetadata(e.availabilityZone, e.podName)); err != nil {
+		return nil, err
+	}
+
+	e.trackMessage(len(*msg), clientZone, clientPodName)
+	return msg, nil
+}
+
+func (e *EchoServer) EchoStream(stream grpc.ServerStream) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	clientZone, clientPodName := identityFromMetadata(md)
+	logger := e.log.With(slog.String("client-az", clientZone), slog.String("client-pod-name", clientPodName))
+
+	if err := stream.SendHeader(identityMetadata(e.availabilityZone, e.podName)); err != nil {
+		return err
+	}
+
+	logger.Info("Stream opened")
+	var msg []byte
+	var total int64
+	for {
+		if err := stream.RecvMsg(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				logger.Info("Stream closed", slog.Int64("bytes", total))
+				return nil
+			}
+			return err
+		}
+		if err := stream.SendMsg(&msg); err != nil {
+			return err
+		}
+		e.trackMessage(len(msg), clientZone, clientPodName)
+		total += int64(len(msg))
+	}
+}
+
+func (e *EchoServer) trackMessage(size int, clientZone, clientPodName string) {
+	// egress traffic from the client to the server
+	metrics.TrackTraffic(
+		float64(size), true, "grpc",
+		clientPodName, clientZone,
+		e.availabilityZone, e.podName,
+	)
+	// egress traffic from the server to the client
+	metrics.TrackTraffic(
+		float64(size), true, "grpc",
+		e.podName, e.availabilityZone,
+		clientZone, clientPodName,
+	)
+}
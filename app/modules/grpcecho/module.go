@@ -0,0 +1,69 @@
+package grpcecho
+
+import (
+	"context"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/Tsonov/cast-taler/app/pkg/module"
+)
+
+var (
+	clientFlags = pflag.NewFlagSet("grpc-echo-client", pflag.ExitOnError)
+	serverFlags = pflag.NewFlagSet("grpc-echo-server", pflag.ExitOnError)
+
+	grpcPort          = clientFlags.Int("grpc-port", 9000, "Port of gRPC echo server")
+	serverAddress     = clientFlags.String("grpc-server-address", "echo-server", "Address of gRPC echo server")
+	mode              = clientFlags.String("grpc-mode", ModeUnary, "gRPC call mode, one of unary or stream")
+	messagesPerSecond = clientFlags.Int("grpc-messages-per-second", 10, "number of messages per second for gRPC echo client")
+	messageSize       = clientFlags.Int("grpc-message-size", 64*1024, "payload bytes per gRPC message")
+	streamLifetime    = clientFlags.Duration("grpc-stream-lifetime", time.Hour, "how long a bidirectional stream is kept open before a new one is started")
+	retryDelay        = clientFlags.Duration("grpc-retry-delay", time.Second, "pause before retrying after a failed call or stream")
+
+	listenIP = serverFlags.String("grpc-server-listen-ip", "0.0.0.0", "IP of gRPC echo server")
+)
+
+func init() {
+	// the port is shared, the client dials it and the server listens on it
+	serverFlags.AddFlag(clientFlags.Lookup("grpc-port"))
+
+	module.Register(clientModule{})
+	module.Register(serverModule{})
+}
+
+type clientModule struct{}
+
+func (clientModule) Name() string {
+	return "grpc-echo-client"
+}
+
+func (clientModule) Description() string {
+	return "sends unary or bidirectional streaming gRPC echo calls over one long lived connection"
+}
+
+func (clientModule) Flags() *pflag.FlagSet {
+	return clientFlags
+}
+
+func (clientModule) Run(ctx context.Context, env module.Env) error {
+	return NewEchoClient(env.Logger, env.AvailabilityZone, env.PodName).Run(ctx, *mode, *messagesPerSecond, *messageSize)
+}
+
+type serverModule struct{}
+
+func (serverModule) Name() string {
+	return "grpc-echo-server"
+}
+
+func (serverModule) Description() string {
+	return "echoes gRPC messages back and tracks gRPC traffic per zone"
+}
+
+func (serverModule) Flags() *pflag.FlagSet {
+	return serverFlags
+}
+
+func (serverModule) Run(ctx context.Context, env module.Env) error {
+	return NewEchoServer(env.Logger, env.AvailabilityZone, env.PodName).Run(ctx)
+}
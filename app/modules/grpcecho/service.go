@@ -0,0 +1,70 @@
+package grpcecho
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/Tsonov/cast-taler/app/modules/echo"
+)
+
+const (
+	serviceName      = "taler.Echo"
+	echoMethod       = "/" + serviceName + "/Echo"
+	echoStreamMethod = "/" + serviceName + "/EchoStream"
+)
+
+// metadata keys are lowercase on the wire
+var (
+	availabilityZoneKey = strings.ToLower(echo.AvailabilityZoneHeader)
+	podNameKey          = strings.ToLower(echo.PodNameHeader)
+)
+
+// echoHandler is implemented by EchoServer, serviceDesc routes both methods to it
+type echoHandler interface {
+	Echo(ctx context.Context, msg *[]byte) (*[]byte, error)
+	EchoStream(stream grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*echoHandler)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Echo",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				msg := new([]byte)
+				if err := dec(msg); err != nil {
+					return nil, err
+				}
+				return srv.(echoHandler).Echo(ctx, msg)
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "EchoStream",
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				return srv.(echoHandler).EchoStream(stream)
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+}
+
+func identityMetadata(availabilityZone, podName string) metadata.MD {
+	return metadata.Pairs(availabilityZoneKey, availabilityZone, podNameKey, podName)
+}
+
+func identityFromMetadata(md metadata.MD) (availabilityZone, podName string) {
+	if v := md.Get(availabilityZoneKey); len(v) > 0 {
+		availabilityZone = v[0]
+	}
+	if v := md.Get(podNameKey); len(v) > 0 {
+		podName = v[0]
+	}
+	return availabilityZone, podName
+}
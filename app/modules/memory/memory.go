@@ -0,0 +1,173 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/Tsonov/cast-taler/app/pkg/metrics"
+	"github.com/Tsonov/cast-taler/app/pkg/module"
+)
+
+const (
+	MB       = 1024 * 1024
+	pageSize = 4096
+
+	ProfileSteady   = "steady"
+	ProfileSawtooth = "sawtooth"
+	ProfileStep     = "step"
+)
+
+var (
+	flags = pflag.NewFlagSet("memory", pflag.ExitOnError)
+
+	profile       = flags.String("memory-profile", ProfileSteady, "allocation profile, one of steady, sawtooth or step")
+	minSizeMB     = flags.Int("memory-min-mb", 0, "lower bound of the working set in MB, used by sawtooth and step profiles")
+	maxSizeMB     = flags.Int("memory-max-mb", 128, "upper bound of the working set in MB, steady profile always holds this much")
+	period        = flags.Duration("memory-period", 5*time.Minute, "length of one sawtooth or step cycle")
+	steps         = flags.Int("memory-steps", 4, "number of steps per cycle for the step profile")
+	touchInterval = flags.Duration("memory-touch-interval", time.Second, "how often the working set is resized and every page written to")
+)
+
+func init() {
+	module.Register(memoryModule{})
+}
+
+type memoryModule struct{}
+
+func (memoryModule) Name() string {
+	return "memory"
+}
+
+func (memoryModule) Description() string {
+	return "allocates and keeps touching a working set to put the pod under memory pressure"
+}
+
+func (memoryModule) Flags() *pflag.FlagSet {
+	return flags
+}
+
+func (memoryModule) Run(ctx context.Context, env module.Env) error {
+	p := Profile{
+		Kind:   *profile,
+		MinMB:  *minSizeMB,
+		MaxMB:  *maxSizeMB,
+		Period: *period,
+		Steps:  *steps,
+	}
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	return NewPressure(env.Logger, p).Run(ctx, *touchInterval)
+}
+
+// Profile describes how the size of the working set changes over time
+type Profile struct {
+	Kind   string
+	MinMB  int
+	MaxMB  int
+	Period time.Duration
+	Steps  int
+}
+
+func (p Profile) Validate() error {
+	switch p.Kind {
+	case ProfileSteady, ProfileSawtooth, ProfileStep:
+	default:
+		return fmt.Errorf("unknown memory profile %q", p.Kind)
+	}
+	if p.MinMB < 0 || p.MaxMB < 0 {
+		return fmt.Errorf("memory sizes cannot be negative")
+	}
+	if p.MinMB > p.MaxMB {
+		return fmt.Errorf("memory min %dMB is larger than max %dMB", p.MinMB, p.MaxMB)
+	}
+	if p.Kind != ProfileSteady && p.Period <= 0 {
+		return fmt.Errorf("memory period must be positive for the %s profile", p.Kind)
+	}
+	if p.Kind == ProfileStep && p.Steps < 1 {
+		return fmt.Errorf("memory steps must be at least 1")
+	}
+	return nil
+}
+
+// TargetMB returns the working set size the profile asks for after elapsed time
+func (p Profile) TargetMB(elapsed time.Duration) int {
+	if p.Kind == ProfileSteady {
+		return p.MaxMB
+	}
+	span := p.MaxMB - p.MinMB
+	phase := float64(elapsed%p.Period) / float64(p.Period)
+	if p.Kind == ProfileStep {
+		step := int(phase * float64(p.Steps))
+		if p.Steps == 1 {
+			return p.MaxMB
+		}
+		return p.MinMB + span*step/(p.Steps-1)
+	}
+	return p.MinMB + int(phase*float64(span))
+}
+
+// Pressure holds a working set made of 1MB chunks
+type Pressure struct {
+	log     *slog.Logger
+	profile Profile
+	chunks  [][]byte
+}
+
+func NewPressure(log *slog.Logger, profile Profile) *Pressure {
+	return &Pressure{
+		log:     log.With("profile", profile.Kind),
+		profile: profile,
+	}
+}
+
+func (p *Pressure) Run(ctx context.Context, interval time.Duration) error {
+	start := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer p.resize(0)
+
+	for {
+		p.resize(p.profile.TargetMB(time.Since(start)))
+		p.touch()
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pressure) resize(targetMB int) {
+	current := len(p.chunks)
+	if targetMB == current {
+		return
+	}
+	if targetMB > current {
+		for i := current; i < targetMB; i++ {
+			p.chunks = append(p.chunks, make([]byte, MB))
+		}
+	} else {
+		clear(p.chunks[targetMB:])
+		p.chunks = p.chunks[:targetMB]
+		// give the pages back right away, otherwise the drop does not show up in the pod RSS
+		debug.FreeOSMemory()
+	}
+	p.log.Info("Resized working set", slog.Int("from-mb", current), slog.Int("to-mb", targetMB))
+	metrics.SetMemoryAllocated(p.profile.Kind, float64(targetMB*MB))
+}
+
+// touch writes to every page so the working set stays resident
+func (p *Pressure) touch() {
+	for _, chunk := range p.chunks {
+		for i := 0; i < len(chunk); i += pageSize {
+			chunk[i]++
+		}
+	}
+}
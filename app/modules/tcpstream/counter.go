@@ -0,0 +1,75 @@
+package tcpstream
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/Tsonov/cast-taler/app/pkg/identity"
+	"github.com/Tsonov/cast-taler/app/pkg/metrics"
+)
+
+// trafficFlusher accumulates bytes of a long lived connection and reports
+// them to TrackTraffic periodically, so the traffic is visible before the
+// connection closes.
+type trafficFlusher struct {
+	local, remote identity.Identity
+	// sent is local -> remote, received is remote -> local
+	sent, received atomic.Int64
+}
+
+func (f *trafficFlusher) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.flush()
+		}
+	}
+}
+
+// flush reports what was counted since the last call
+func (f *trafficFlusher) flush() {
+	if n := f.received.Swap(0); n > 0 {
+		metrics.TrackTraffic(
+			float64(n), true, "tcp",
+			f.remote.Pod, f.remote.Zone,
+			f.local.Zone, f.local.Pod,
+		)
+	}
+	if n := f.sent.Swap(0); n > 0 {
+		metrics.TrackTraffic(
+			float64(n), true, "tcp",
+			f.local.Pod, f.local.Zone,
+			f.remote.Zone, f.remote.Pod,
+		)
+	}
+}
+
+// countingWriter adds every written byte to counter
+type countingWriter struct {
+	w       io.Writer
+	counter *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.counter.Add(int64(n))
+	return n, err
+}
+
+// countingReader adds every read byte to counter
+type countingReader struct {
+	r       io.Reader
+	counter *atomic.Int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.counter.Add(int64(n))
+	return n, err
+}
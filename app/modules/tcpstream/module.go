@@ -0,0 +1,72 @@
+package tcpstream
+
+import (
+	"context"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/Tsonov/cast-taler/app/pkg/module"
+)
+
+var (
+	clientFlags = pflag.NewFlagSet("tcp-stream-client", pflag.ExitOnError)
+	serverFlags = pflag.NewFlagSet("tcp-stream-server", pflag.ExitOnError)
+
+	tcpPort        = clientFlags.Int("tcp-port", 10001, "Port of TCP stream server")
+	serverAddress  = clientFlags.String("tcp-server-address", "echo-server", "Address of TCP stream server")
+	connections    = clientFlags.Int("tcp-connections", 1, "number of connections the TCP stream client keeps open")
+	lifetime       = clientFlags.Duration("tcp-connection-lifetime", time.Hour, "how long a connection is used before it is replaced by a new one")
+	bytesPerSecond = clientFlags.Int("tcp-bytes-per-second", MB, "bytes per second sent over each connection")
+	chunkSize      = clientFlags.Int("tcp-chunk-size", 32*1024, "size of a single write on the connection")
+	retryDelay     = clientFlags.Duration("tcp-retry-delay", time.Second, "pause before reconnecting after a failed connection")
+
+	listenIP      = serverFlags.String("tcp-server-listen-ip", "0.0.0.0", "IP of TCP stream server")
+	flushInterval = serverFlags.Duration("tcp-flush-interval", 10*time.Second, "how often traffic of open connections is reported to metrics")
+)
+
+const MB = 1024 * 1024
+
+func init() {
+	// the port is shared, the client dials it and the server listens on it
+	serverFlags.AddFlag(clientFlags.Lookup("tcp-port"))
+
+	module.Register(clientModule{})
+	module.Register(serverModule{})
+}
+
+type clientModule struct{}
+
+func (clientModule) Name() string {
+	return "tcp-stream-client"
+}
+
+func (clientModule) Description() string {
+	return "streams data over long lived TCP connections at a fixed throughput"
+}
+
+func (clientModule) Flags() *pflag.FlagSet {
+	return clientFlags
+}
+
+func (clientModule) Run(ctx context.Context, env module.Env) error {
+	return NewEchoClient(env.Logger, env.AvailabilityZone, env.PodName).Run(ctx, *connections, *lifetime, *bytesPerSecond)
+}
+
+type serverModule struct{}
+
+func (serverModule) Name() string {
+	return "tcp-stream-server"
+}
+
+func (serverModule) Description() string {
+	return "echoes TCP streams back and reports their traffic while connections are open"
+}
+
+func (serverModule) Flags() *pflag.FlagSet {
+	return serverFlags
+}
+
+func (serverModule) Run(ctx context.Context, env module.Env) error {
+	return NewEchoServer(env.Logger, env.AvailabilityZone, env.PodName).Run(ctx)
+}
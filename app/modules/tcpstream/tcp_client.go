@@ -0,0 +1,140 @@
+package tcpstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/Tsonov/cast-taler/app/pkg/identity"
+)
+
+type EchoClient struct {
+	log  *slog.Logger
+	self identity.Identity
+}
+
+func NewEchoClient(log *slog.Logger, availabilityZone, podName string) *EchoClient {
+	return &EchoClient{
+		log:  log,
+		self: identity.Identity{Zone: availabilityZone, Pod: podName},
+	}
+}
+
+// Run keeps the given number of connections open, each one replaced by a new
+// one after lifetime
+func (e *EchoClient) Run(ctx context.Context, connections int, lifetime time.Duration, bytesPerSecond int) error {
+	if connections <= 0 {
+		return fmt.Errorf("tcp connections must be positive, got %d", connections)
+	}
+	if bytesPerSecond <= 0 {
+		return fmt.Errorf("tcp throughput must be positive, got %d", bytesPerSecond)
+	}
+	if *chunkSize <= 0 {
+		return fmt.Errorf("tcp chunk size must be positive, got %d", *chunkSize)
+	}
+	if lifetime <= 0 {
+		return fmt.Errorf("tcp connection lifetime must be positive, got %s", lifetime)
+	}
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	for i := 0; i < connections; i++ {
+		logger := e.log.With(slog.Int("connection", i))
+		group.Go(func() error {
+			for {
+				err := e.stream(groupCtx, logger, lifetime, bytesPerSecond)
+				if groupCtx.Err() != nil {
+					return groupCtx.Err()
+				}
+				if err != nil {
+					logger.Error("Stream failed, reconnecting", slog.Any("error", err))
+					select {
+					case <-groupCtx.Done():
+						return groupCtx.Err()
+					case <-time.After(*retryDelay):
+					}
+				}
+			}
+		})
+	}
+	return group.Wait()
+}
+
+// stream opens one connection and sends data at bytesPerSecond until lifetime
+// is reached, while a second goroutine drains the echoed data
+func (e *EchoClient) stream(ctx context.Context, logger *slog.Logger, lifetime time.Duration, bytesPerSecond int) error {
+	addr := net.JoinHostPort(*serverAddress, strconv.Itoa(*tcpPort))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("dialing %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	header, err := e.self.AppendHeader(nil)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if _, err := conn.Write(header); err != nil {
+		return fmt.Errorf("writing header: %w", err)
+	}
+	server, err := identity.ReadHeader(conn)
+	if err != nil {
+		return fmt.Errorf("reading server header: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	logger = logger.With(slog.String("server-az", server.Zone), slog.String("server-pod", server.Pod))
+	logger.Info("Connection opened")
+
+	streamCtx, cancel := context.WithTimeout(ctx, lifetime)
+	defer cancel()
+	go func() {
+		<-streamCtx.Done()
+		conn.Close()
+	}()
+
+	received := make(chan int64, 1)
+	go func() {
+		n, _ := io.Copy(io.Discard, conn)
+		received <- n
+	}()
+
+	chunk := make([]byte, *chunkSize)
+	rand.Read(chunk)
+
+	start := time.Now()
+	var sent int64
+	for streamCtx.Err() == nil {
+		n, err := conn.Write(chunk)
+		sent += int64(n)
+		if err != nil {
+			if streamCtx.Err() != nil {
+				break
+			}
+			return fmt.Errorf("writing data: %w", err)
+		}
+
+		// pace writes so the average rate matches bytesPerSecond
+		due := start.Add(time.Duration(float64(sent) / float64(bytesPerSecond) * float64(time.Second)))
+		select {
+		case <-streamCtx.Done():
+		case <-time.After(time.Until(due)):
+		}
+	}
+
+	cancel()
+	logger.Info("Connection closed", slog.Int64("sent", sent), slog.Int64("received", <-received), slog.Duration("duration", time.Since(start)))
+	if errors.Is(streamCtx.Err(), context.DeadlineExceeded) {
+		return nil
+	}
+	return ctx.Err()
+}
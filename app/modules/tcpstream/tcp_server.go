@@ -0,0 +1,110 @@
+package tcpstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Tsonov/cast-taler/app/pkg/identity"
+)
+
+const handshakeTimeout = 10 * time.Second
+
+type EchoServer struct {
+	log  *slog.Logger
+	self identity.Identity
+}
+
+func NewEchoServer(log *slog.Logger, availabilityZone, podName string) *EchoServer {
+	return &EchoServer{
+		log:  log.With("server-az", availabilityZone),
+		self: identity.Identity{Zone: availabilityZone, Pod: podName},
+	}
+}
+
+func (e *EchoServer) Run(ctx context.Context) error {
+	if *flushInterval <= 0 {
+		return fmt.Errorf("tcp flush interval must be positive, got %s", *flushInterval)
+	}
+	addr := net.JoinHostPort(*listenIP, strconv.Itoa(*tcpPort))
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", addr, err)
+	}
+	e.log.Info("TCP stream server listening", slog.String("address", addr))
+
+	go func() {
+		<-ctx.Done()
+		lis.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("accepting connection: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.handleConnection(ctx, conn)
+		}()
+	}
+}
+
+func (e *EchoServer) handleConnection(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	logger := e.log.With(slog.String("client-addr", conn.RemoteAddr().String()))
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	client, err := identity.ReadHeader(conn)
+	if err != nil {
+		logger.Warn("Error reading client header", slog.Any("error", err))
+		return
+	}
+	header, err := e.self.AppendHeader(nil)
+	if err != nil {
+		logger.Error("Error encoding header", slog.Any("error", err))
+		return
+	}
+	if _, err := conn.Write(header); err != nil {
+		logger.Warn("Error writing header", slog.Any("error", err))
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	logger = logger.With(slog.String("client-az", client.Zone), slog.String("client-pod-name", client.Pod))
+	logger.Info("Connection opened")
+
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		// unblock the copy below on shutdown
+		<-connCtx.Done()
+		conn.Close()
+	}()
+
+	flusher := &trafficFlusher{local: e.self, remote: client}
+	go flusher.run(connCtx, *flushInterval)
+
+	// every byte read is written back, so reads are counted as received and
+	// the writes as sent
+	start := time.Now()
+	written, err := io.Copy(countingWriter{w: conn, counter: &flusher.sent}, countingReader{r: conn, counter: &flusher.received})
+	cancel()
+	flusher.flush()
+	if err != nil && ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+		logger.Warn("Error echoing data", slog.Any("error", err))
+	}
+	logger.Info("Connection closed", slog.Int64("bytes", written), slog.Duration("duration", time.Since(start)))
+}
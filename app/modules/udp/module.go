@@ -0,0 +1,68 @@
+package udp
+
+import (
+	"context"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/Tsonov/cast-taler/app/pkg/module"
+)
+
+var (
+	clientFlags = pflag.NewFlagSet("udp-echo-client", pflag.ExitOnError)
+	serverFlags = pflag.NewFlagSet("udp-echo-server", pflag.ExitOnError)
+
+	udpPort          = clientFlags.Int("udp-port", 10000, "Port of UDP echo server")
+	serverAddress    = clientFlags.String("udp-server-address", "echo-server", "Address of UDP echo server")
+	packetsPerSecond = clientFlags.Int("udp-packets-per-second", 100, "number of datagrams per second for UDP echo client")
+	payloadSize      = clientFlags.Int("udp-payload-size", 1024, "payload bytes per datagram, excluding the identity header")
+	readTimeout      = clientFlags.Duration("udp-read-timeout", time.Second, "how long the UDP echo client waits for a reply before checking for shutdown")
+	reportInterval   = clientFlags.Duration("udp-report-interval", 10*time.Second, "how often the UDP echo client logs received bytes per server")
+
+	listenIP = serverFlags.String("udp-server-listen-ip", "0.0.0.0", "IP of UDP echo server")
+)
+
+func init() {
+	// the port is shared, the client dials it and the server listens on it
+	serverFlags.AddFlag(clientFlags.Lookup("udp-port"))
+
+	module.Register(clientModule{})
+	module.Register(serverModule{})
+}
+
+type clientModule struct{}
+
+func (clientModule) Name() string {
+	return "udp-echo-client"
+}
+
+func (clientModule) Description() string {
+	return "sends datagrams tagged with the pod zone to the UDP echo server"
+}
+
+func (clientModule) Flags() *pflag.FlagSet {
+	return clientFlags
+}
+
+func (clientModule) Run(ctx context.Context, env module.Env) error {
+	return NewEchoClient(env.Logger, env.AvailabilityZone, env.PodName).Run(ctx, *packetsPerSecond, *payloadSize)
+}
+
+type serverModule struct{}
+
+func (serverModule) Name() string {
+	return "udp-echo-server"
+}
+
+func (serverModule) Description() string {
+	return "echoes datagrams back and tracks UDP traffic per zone"
+}
+
+func (serverModule) Flags() *pflag.FlagSet {
+	return serverFlags
+}
+
+func (serverModule) Run(ctx context.Context, env module.Env) error {
+	return NewEchoServer(env.Logger, env.AvailabilityZone, env.PodName).Run(ctx)
+}
@@ -0,0 +1,105 @@
+package udp
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Tsonov/cast-taler/app/pkg/identity"
+)
+
+type EchoClient struct {
+	log      *slog.Logger
+	identity identity.Identity
+}
+
+func NewEchoClient(log *slog.Logger, availabilityZone, podName string) *EchoClient {
+	return &EchoClient{
+		log:      log,
+		identity: identity.Identity{Zone: availabilityZone, Pod: podName},
+	}
+}
+
+func (e *EchoClient) Run(ctx context.Context, packetsPerSecond, payloadSize int) error {
+	if packetsPerSecond <= 0 || packetsPerSecond > int(time.Second) {
+		return fmt.Errorf("udp packets per second must be between 1 and %d, got %d", int(time.Second), packetsPerSecond)
+	}
+	if payloadSize < 0 {
+		return fmt.Errorf("udp payload size cannot be negative, got %d", payloadSize)
+	}
+
+	packet, err := e.identity.AppendHeader(nil)
+	if err != nil {
+		return err
+	}
+	if len(packet)+payloadSize > maxDatagramSize {
+		return fmt.Errorf("udp payload size %d does not fit in a datagram with a %d byte header", payloadSize, len(packet))
+	}
+	payload := make([]byte, payloadSize)
+	rand.Read(payload)
+	packet = append(packet, payload...)
+
+	addr := net.JoinHostPort(*serverAddress, strconv.Itoa(*udpPort))
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return fmt.Errorf("dialing %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	// replies are read in the background so a lost datagram does not stall sending
+	go e.receive(ctx, conn)
+
+	ticker := time.NewTicker(time.Second / time.Duration(packetsPerSecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		// UDP write errors are usually ICMP unreachable from a restarting server, keep going
+		if _, err := conn.Write(packet); err != nil && ctx.Err() == nil {
+			e.log.Warn("Error sending datagram", slog.Any("error", err))
+		}
+	}
+}
+
+func (e *EchoClient) receive(ctx context.Context, conn net.Conn) {
+	buf := make([]byte, maxDatagramSize)
+	received := map[identity.Identity]int{}
+	lastReport := time.Now()
+	for {
+		conn.SetReadDeadline(time.Now().Add(*readTimeout))
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				e.log.Warn("Error receiving datagram", slog.Any("error", err))
+			}
+		} else if server, _, err := identity.ParseHeader(buf[:n]); err == nil {
+			received[server] += n
+		}
+
+		if time.Since(lastReport) >= *reportInterval {
+			for server, bytes := range received {
+				e.log.Info("Received data", slog.String("server-az", server.Zone), slog.String("server-pod", server.Pod), slog.Int("bytes", bytes))
+			}
+			clear(received)
+			lastReport = time.Now()
+		}
+	}
+}
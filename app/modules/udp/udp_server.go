@@ -0,0 +1,87 @@
+package udp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+
+	"github.com/Tsonov/cast-taler/app/pkg/identity"
+	"github.com/Tsonov/cast-taler/app/pkg/metrics"
+)
+
+// maxDatagramSize is the largest UDP payload over IPv4
+const maxDatagramSize = 65507
+
+type EchoServer struct {
+	log      *slog.Logger
+	identity identity.Identity
+}
+
+func NewEchoServer(log *slog.Logger, availabilityZone, podName string) *EchoServer {
+	return &EchoServer{
+		log:      log.With("server-az", availabilityZone),
+		identity: identity.Identity{Zone: availabilityZone, Pod: podName},
+	}
+}
+
+func (e *EchoServer) Run(ctx context.Context) error {
+	addr := net.JoinHostPort(*listenIP, strconv.Itoa(*udpPort))
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", addr, err)
+	}
+	e.log.Info("UDP echo server listening", slog.String("address", addr))
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, maxDatagramSize)
+	reply := make([]byte, 0, maxDatagramSize)
+	for {
+		n, clientAddr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("reading datagram: %w", err)
+		}
+
+		client, payload, err := identity.ParseHeader(buf[:n])
+		if err != nil {
+			e.log.Debug("Dropping datagram", slog.String("client-addr", clientAddr.String()), slog.Any("error", err))
+			continue
+		}
+
+		// egress traffic from the client to the server
+		metrics.TrackTraffic(
+			float64(n), true, "udp",
+			client.Pod, client.Zone,
+			e.identity.Zone, e.identity.Pod,
+		)
+
+		reply, err = e.identity.AppendHeader(reply[:0])
+		if err != nil {
+			return err
+		}
+		// the reply carries our identity, trim the payload so it still fits one datagram
+		reply = append(reply, payload[:min(len(payload), maxDatagramSize-len(reply))]...)
+
+		written, err := conn.WriteTo(reply, clientAddr)
+		success := err == nil
+		if err != nil {
+			e.log.Error("Error writing datagram", slog.String("client-addr", clientAddr.String()), slog.Any("error", err))
+		}
+
+		// egress traffic from the server to the client
+		metrics.TrackTraffic(
+			float64(written), success, "udp",
+			e.identity.Pod, e.identity.Zone,
+			client.Zone, client.Pod,
+		)
+	}
+}
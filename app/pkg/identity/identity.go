@@ -0,0 +1,89 @@
+package identity
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// magic prefixes every header so stray traffic on the port is ignored
+var magic = [4]byte{'T', 'L', 'R', '1'}
+
+var ErrNoHeader = errors.New("missing identity header")
+
+// Identity is the non HTTP equivalent of the Availability-Zone and Pod-Name
+// headers. It is encoded at the start of every datagram or stream as
+//
+//	magic | zone length (1 byte) | zone | pod length (1 byte) | pod
+type Identity struct {
+	Zone string
+	Pod  string
+}
+
+// AppendHeader appends the encoded identity to buf
+func (i Identity) AppendHeader(buf []byte) ([]byte, error) {
+	if len(i.Zone) > 255 || len(i.Pod) > 255 {
+		return nil, fmt.Errorf("zone and pod names must be at most 255 bytes")
+	}
+	buf = append(buf, magic[:]...)
+	buf = append(buf, byte(len(i.Zone)))
+	buf = append(buf, i.Zone...)
+	buf = append(buf, byte(len(i.Pod)))
+	buf = append(buf, i.Pod...)
+	return buf, nil
+}
+
+// ParseHeader decodes the identity at the start of packet and returns the
+// remaining payload
+func ParseHeader(packet []byte) (Identity, []byte, error) {
+	if len(packet) < len(magic)+1 || [4]byte(packet[:len(magic)]) != magic {
+		return Identity{}, nil, ErrNoHeader
+	}
+	rest := packet[len(magic):]
+
+	zone, rest, err := readString(rest)
+	if err != nil {
+		return Identity{}, nil, fmt.Errorf("reading zone: %w", err)
+	}
+	pod, rest, err := readString(rest)
+	if err != nil {
+		return Identity{}, nil, fmt.Errorf("reading pod: %w", err)
+	}
+	return Identity{Zone: zone, Pod: pod}, rest, nil
+}
+
+// ReadHeader decodes the identity from the start of a stream
+func ReadHeader(r io.Reader) (Identity, error) {
+	var prefix [len(magic)]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return Identity{}, err
+	}
+	if prefix != magic {
+		return Identity{}, ErrNoHeader
+	}
+
+	var fields [2]string
+	for i := range fields {
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return Identity{}, fmt.Errorf("reading header: %w", err)
+		}
+		buf := make([]byte, n[0])
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return Identity{}, fmt.Errorf("reading header: %w", err)
+		}
+		fields[i] = string(buf)
+	}
+	return Identity{Zone: fields[0], Pod: fields[1]}, nil
+}
+
+func readString(buf []byte) (string, []byte, error) {
+	if len(buf) < 1 {
+		return "", nil, errors.New("truncated header")
+	}
+	n := int(buf[0])
+	if len(buf) < 1+n {
+		return "", nil, errors.New("truncated header")
+	}
+	return string(buf[1 : 1+n]), buf[1+n:], nil
+}
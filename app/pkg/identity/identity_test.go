@@ -0,0 +1,63 @@
+package identity
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		id   Identity
+	}{
+		{name: "zone and pod", id: Identity{Zone: "us-east1-b", Pod: "echo-client-7d9f"}},
+		{name: "empty", id: Identity{}},
+		{name: "longest names", id: Identity{Zone: strings.Repeat("z", 255), Pod: strings.Repeat("p", 255)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, err := tt.id.AppendHeader(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			packet := append(header, "payload"...)
+
+			id, rest, err := ParseHeader(packet)
+			if err != nil || id != tt.id || string(rest) != "payload" {
+				t.Errorf("ParseHeader: got %+v, %q, %v", id, rest, err)
+			}
+			id, err = ReadHeader(bytes.NewReader(packet))
+			if err != nil || id != tt.id {
+				t.Errorf("ReadHeader: got %+v, %v", id, err)
+			}
+		})
+	}
+}
+
+func TestHeaderErrors(t *testing.T) {
+	if _, err := (Identity{Zone: strings.Repeat("z", 256)}).AppendHeader(nil); err == nil {
+		t.Error("a zone name longer than 255 bytes was encoded")
+	}
+
+	header, _ := Identity{Zone: "us-east1-b", Pod: "pod"}.AppendHeader(nil)
+	tests := []struct {
+		name   string
+		packet []byte
+		noHdr  bool
+	}{
+		{name: "stray traffic", packet: []byte("GET / HTTP/1.1\r\n"), noHdr: true},
+		{name: "too short", packet: []byte("TLR"), noHdr: true},
+		{name: "truncated zone", packet: header[:8]},
+		{name: "truncated pod", packet: header[:len(header)-1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ParseHeader(tt.packet)
+			if err == nil || errors.Is(err, ErrNoHeader) != tt.noHdr {
+				t.Errorf("got %v, want missing header %v", err, tt.noHdr)
+			}
+		})
+	}
+}
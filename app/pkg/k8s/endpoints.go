@@ -0,0 +1,327 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Ways to pick an endpoint of a Service
+const (
+	// EndpointModeRandom picks any ready endpoint, like kube-proxy without
+	// topology aware routing
+	EndpointModeRandom = "random"
+	// EndpointModeSameZone picks endpoints in the client zone and falls back to
+	// all endpoints when the zone has none
+	EndpointModeSameZone = "same-zone"
+	// EndpointModeTopology follows the zone hints of the EndpointSlices and
+	// falls back to all endpoints when none is hinted for the client zone
+	EndpointModeTopology = "topology"
+)
+
+const namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+func ValidEndpointMode(mode string) bool {
+	return mode == EndpointModeRandom || mode == EndpointModeSameZone || mode == EndpointModeTopology
+}
+
+// Endpoint is one ready address of a Service
+type Endpoint struct {
+	IP   string
+	Port int32
+	Zone string
+	Pod  string
+	// ForZones are the zones the EndpointSlice controller hints this endpoint for
+	ForZones []string
+}
+
+// Address is the host:port to send requests to
+func (ep Endpoint) Address() string {
+	return net.JoinHostPort(ep.IP, strconv.Itoa(int(ep.Port)))
+}
+
+// EndpointWatcher keeps the ready endpoints of a Service, listed once and then
+// updated from a watch on its EndpointSlices
+type EndpointWatcher struct {
+	log       *slog.Logger
+	cl        client.WithWatch
+	namespace string
+	service   string
+	port      int32
+	// onRemove is called with the addresses of endpoints that left the Service
+	onRemove func(addresses []string)
+
+	// slices and resourceVersion are only used by Refresh and Run
+	slices          map[string]*discoveryv1.EndpointSlice
+	resourceVersion string
+	// portName is the name of the Service port matching port, EndpointSlices
+	// carry the target port, which only shares the name with it
+	portName      string
+	portNameFound bool
+
+	mu        sync.RWMutex
+	endpoints []Endpoint
+}
+
+// NewEndpointWatcher watches the Service behind address, either a plain name
+// in the pod's namespace or a name.namespace DNS name. Port is the Service
+// port, it picks the slice port when the Service has several.
+func NewEndpointWatcher(log *slog.Logger, cl client.WithWatch, address string, port int) *EndpointWatcher {
+	service, namespace, _ := strings.Cut(address, ".")
+	namespace, _, _ = strings.Cut(namespace, ".")
+	if namespace == "" {
+		namespace = podNamespace()
+	}
+	return &EndpointWatcher{
+		log:       log.With(slog.String("service", namespace+"/"+service)),
+		cl:        cl,
+		namespace: namespace,
+		service:   service,
+		port:      int32(port),
+	}
+}
+
+// OnRemove sets a function called with the addresses of endpoints that are no
+// longer part of the Service, e.g. to drop state kept per endpoint. It must be
+// set before Run.
+func (w *EndpointWatcher) OnRemove(f func(addresses []string)) {
+	w.onRemove = f
+}
+
+// podNamespace is the namespace of the service account, default outside of a
+// cluster
+func podNamespace() string {
+	data, err := os.ReadFile(namespaceFile)
+	if err != nil {
+		return "default"
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Refresh looks up the Service port and lists the EndpointSlices of the Service
+func (w *EndpointWatcher) Refresh(ctx context.Context) error {
+	svc := &corev1.Service{}
+	if err := w.cl.Get(ctx, client.ObjectKey{Namespace: w.namespace, Name: w.service}, svc); err != nil {
+		return fmt.Errorf("failed to get service %s/%s: %w", w.namespace, w.service, err)
+	}
+	w.portName, w.portNameFound = "", false
+	for _, p := range svc.Spec.Ports {
+		if p.Port == w.port {
+			w.portName, w.portNameFound = p.Name, true
+			break
+		}
+	}
+
+	list := &discoveryv1.EndpointSliceList{}
+	if err := w.cl.List(ctx, list, w.listOptions()...); err != nil {
+		return fmt.Errorf("failed to list endpoint slices of %s/%s: %w", w.namespace, w.service, err)
+	}
+	w.slices = map[string]*discoveryv1.EndpointSlice{}
+	for i := range list.Items {
+		w.slices[list.Items[i].Name] = &list.Items[i]
+	}
+	w.resourceVersion = list.ResourceVersion
+	w.update()
+	return nil
+}
+
+func (w *EndpointWatcher) listOptions() []client.ListOption {
+	return []client.ListOption{
+		client.InNamespace(w.namespace),
+		client.MatchingLabels{discoveryv1.LabelServiceName: w.service},
+	}
+}
+
+// update rebuilds the endpoints from the slices
+func (w *EndpointWatcher) update() {
+	var endpoints []Endpoint
+	for _, slice := range w.slices {
+		port, ok := slicePort(slice, w.portName, w.portNameFound)
+		if !ok {
+			continue
+		}
+		for _, ep := range slice.Endpoints {
+			// a missing ready condition means ready
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+			endpoint := Endpoint{Port: port}
+			if ep.Zone != nil {
+				endpoint.Zone = *ep.Zone
+			}
+			if ep.TargetRef != nil {
+				endpoint.Pod = ep.TargetRef.Name
+			}
+			if ep.Hints != nil {
+				for _, z := range ep.Hints.ForZones {
+					endpoint.ForZones = append(endpoint.ForZones, z.Name)
+				}
+			}
+			for _, ip := range ep.Addresses {
+				endpoint.IP = ip
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+	}
+
+	w.mu.Lock()
+	old := w.endpoints
+	w.endpoints = endpoints
+	w.mu.Unlock()
+
+	if w.onRemove == nil {
+		return
+	}
+	current := map[string]bool{}
+	for _, ep := range endpoints {
+		current[ep.Address()] = true
+	}
+	var removed []string
+	for _, ep := range old {
+		if addr := ep.Address(); !current[addr] {
+			removed = append(removed, addr)
+			// an address is listed once per slice port it is in
+			current[addr] = true
+		}
+	}
+	if len(removed) > 0 {
+		w.onRemove(removed)
+	}
+}
+
+// slicePort returns the slice port with the name of the Service port, or its
+// only port when the Service has no port matching the one of the watcher
+func slicePort(slice *discoveryv1.EndpointSlice, name string, named bool) (int32, bool) {
+	if named {
+		for _, p := range slice.Ports {
+			if p.Port != nil && ptrValue(p.Name) == name {
+				return *p.Port, true
+			}
+		}
+		return 0, false
+	}
+	if len(slice.Ports) == 1 && slice.Ports[0].Port != nil {
+		return *slice.Ports[0].Port, true
+	}
+	return 0, false
+}
+
+func ptrValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Run keeps the endpoints up to date from a watch on the EndpointSlices,
+// Refresh must have been called before. When the watch fails the last known
+// endpoints are kept and the slices are listed again after retry.
+func (w *EndpointWatcher) Run(ctx context.Context, retry time.Duration) {
+	for {
+		err := w.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			// the API server ends watches after a while, continue where it ended
+			continue
+		}
+		w.log.Warn("Watching endpoints failed, keeping the last ones", slog.Any("error", err))
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry):
+			}
+			err := w.Refresh(ctx)
+			if err == nil {
+				break
+			}
+			if ctx.Err() == nil {
+				w.log.Warn("Failed to list endpoints, keeping the last ones", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// watch applies EndpointSlice events until the watch ends, an error means the
+// slices have to be listed again
+func (w *EndpointWatcher) watch(ctx context.Context) error {
+	opts := append(w.listOptions(), &client.ListOptions{Raw: &metav1.ListOptions{ResourceVersion: w.resourceVersion}})
+	watcher, err := w.cl.Watch(ctx, &discoveryv1.EndpointSliceList{}, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to watch endpoint slices of %s/%s: %w", w.namespace, w.service, err)
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return nil
+			}
+			if event.Type == watch.Error {
+				return fmt.Errorf("watch of endpoint slices of %s/%s failed: %v", w.namespace, w.service, event.Object)
+			}
+			slice, ok := event.Object.(*discoveryv1.EndpointSlice)
+			if !ok {
+				continue
+			}
+			w.resourceVersion = slice.ResourceVersion
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				w.slices[slice.Name] = slice
+			case watch.Deleted:
+				delete(w.slices, slice.Name)
+			default:
+				continue
+			}
+			w.update()
+		}
+	}
+}
+
+// Pick returns an endpoint for a client in zone
+func (w *EndpointWatcher) Pick(mode, zone string) (Endpoint, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if len(w.endpoints) == 0 {
+		return Endpoint{}, false
+	}
+
+	var candidates []Endpoint
+	switch mode {
+	case EndpointModeSameZone:
+		for _, ep := range w.endpoints {
+			if ep.Zone == zone {
+				candidates = append(candidates, ep)
+			}
+		}
+	case EndpointModeTopology:
+		for _, ep := range w.endpoints {
+			if slices.Contains(ep.ForZones, zone) {
+				candidates = append(candidates, ep)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = w.endpoints
+	}
+	return candidates[rand.IntN(len(candidates))], true
+}
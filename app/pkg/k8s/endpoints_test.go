@@ -0,0 +1,189 @@
+package k8s
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/watch"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// newSlice returns an EndpointSlice of the echo-server Service with one
+// endpoint per IP and the given named ports
+func newSlice(name string, ports map[string]int32, ips ...string) *discoveryv1.EndpointSlice {
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "echo-server"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+	}
+	for portName, port := range ports {
+		slice.Ports = append(slice.Ports, discoveryv1.EndpointPort{Name: ptr(portName), Port: ptr(port)})
+	}
+	for _, ip := range ips {
+		slice.Endpoints = append(slice.Endpoints, discoveryv1.Endpoint{Addresses: []string{ip}})
+	}
+	return slice
+}
+
+func newService(ports ...corev1.ServicePort) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "echo-server", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Ports: ports},
+	}
+}
+
+func addresses(w *EndpointWatcher) []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var out []string
+	for _, ep := range w.endpoints {
+		out = append(out, ep.Address())
+	}
+	slices.Sort(out)
+	return out
+}
+
+func TestEndpointWatcherPorts(t *testing.T) {
+	tests := []struct {
+		name    string
+		service *corev1.Service
+		slice   *discoveryv1.EndpointSlice
+		port    int
+		want    []string
+	}{
+		{
+			name: "target port differs from the service port",
+			service: newService(
+				corev1.ServicePort{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080)},
+				corev1.ServicePort{Name: "metrics", Port: 9090, TargetPort: intstr.FromInt(9091)},
+			),
+			slice: newSlice("s1", map[string]int32{"http": 8080, "metrics": 9091}, "10.0.0.1"),
+			port:  80,
+			want:  []string{"10.0.0.1:8080"},
+		},
+		{
+			name:    "single unnamed port",
+			service: newService(corev1.ServicePort{Port: 8080, TargetPort: intstr.FromInt(8080)}),
+			slice:   newSlice("s1", map[string]int32{"": 8080}, "10.0.0.1", "10.0.0.2"),
+			port:    8080,
+			want:    []string{"10.0.0.1:8080", "10.0.0.2:8080"},
+		},
+		{
+			name:    "port not in the service falls back to the only slice port",
+			service: newService(corev1.ServicePort{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080)}),
+			slice:   newSlice("s1", map[string]int32{"http": 8080}, "10.0.0.1"),
+			port:    8080,
+			want:    []string{"10.0.0.1:8080"},
+		},
+		{
+			name: "port not in a multi port service",
+			service: newService(
+				corev1.ServicePort{Name: "http", Port: 80},
+				corev1.ServicePort{Name: "metrics", Port: 9090},
+			),
+			slice: newSlice("s1", map[string]int32{"http": 80, "metrics": 9090}, "10.0.0.1"),
+			port:  8080,
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(tt.service, tt.slice).Build()
+			w := NewEndpointWatcher(slog.Default(), cl, "echo-server.default", tt.port)
+			if err := w.Refresh(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if got := addresses(w); !slices.Equal(got, tt.want) {
+				t.Errorf("got endpoints %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEndpointWatcherWatch(t *testing.T) {
+	ports := map[string]int32{"http": 8080}
+	watching := make(chan struct{})
+	var watchOnce sync.Once
+	cl := fake.NewClientBuilder().
+		WithScheme(clientgoscheme.Scheme).
+		WithObjects(
+			newService(corev1.ServicePort{Name: "http", Port: 8080}),
+			newSlice("s1", ports, "10.0.0.1", "10.0.0.2"),
+		).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Watch: func(ctx context.Context, cl client.WithWatch, list client.ObjectList, opts ...client.ListOption) (watch.Interface, error) {
+				w, err := cl.Watch(ctx, list, opts...)
+				watchOnce.Do(func() { close(watching) })
+				return w, err
+			},
+		}).
+		Build()
+	w := NewEndpointWatcher(slog.Default(), cl, "echo-server.default", 8080)
+	removed := make(chan []string, 10)
+	w.OnRemove(func(addresses []string) {
+		removed <- addresses
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := w.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	go w.Run(ctx, time.Second)
+	// the fake client does not replay changes made before the watch started
+	<-watching
+
+	waitFor := func(want ...string) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !slices.Equal(addresses(w), want) {
+			if time.Now().After(deadline) {
+				t.Fatalf("got endpoints %v, want %v", addresses(w), want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if err := cl.Create(ctx, newSlice("s2", ports, "10.0.0.3")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.3:8080")
+
+	s1 := &discoveryv1.EndpointSlice{}
+	if err := cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: "s1"}, s1); err != nil {
+		t.Fatal(err)
+	}
+	s1.Endpoints = s1.Endpoints[:1]
+	if err := cl.Update(ctx, s1); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("10.0.0.1:8080", "10.0.0.3:8080")
+	if got := <-removed; !slices.Equal(got, []string{"10.0.0.2:8080"}) {
+		t.Errorf("removed %v, want [10.0.0.2:8080]", got)
+	}
+
+	if err := cl.Delete(ctx, newSlice("s2", ports)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("10.0.0.1:8080")
+	if got := <-removed; !slices.Equal(got, []string{"10.0.0.3:8080"}) {
+		t.Errorf("removed %v, want [10.0.0.3:8080]", got)
+	}
+}
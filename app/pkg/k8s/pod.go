@@ -0,0 +1,122 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// podCacheTTL bounds how long a recycled pod IP can keep a stale identity
+	podCacheTTL = 5 * time.Minute
+	// podNegativeCacheTTL is shorter, a pod that is still starting resolves
+	// soon after it is running
+	podNegativeCacheTTL = 30 * time.Second
+	// maxCachedPods bounds the cache when clients see many IPs, e.g. node IPs
+	// or pods churning
+	maxCachedPods = 4096
+)
+
+// PodInfo is the identity of the pod behind an IP
+type PodInfo struct {
+	Name string
+	Zone string
+}
+
+// podCacheEntry is a lookup result, failures are cached too so IPs that never
+// resolve, like ClusterIPs and node IPs, do not hit the API server on every
+// request
+type podCacheEntry struct {
+	info    PodInfo
+	err     error
+	expires time.Time
+}
+
+// PodResolver maps pod IPs to pod names and zones. Results are cached with a
+// TTL, failed lookups for a shorter one.
+type PodResolver struct {
+	cl client.Client
+	// now is replaced in tests
+	now func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]podCacheEntry
+}
+
+func NewPodResolver(cl client.Client) *PodResolver {
+	return &PodResolver{
+		cl:    cl,
+		now:   time.Now,
+		cache: map[string]podCacheEntry{},
+	}
+}
+
+func (r *PodResolver) Resolve(ctx context.Context, ip string) (PodInfo, error) {
+	r.mu.Lock()
+	entry, ok := r.cache[ip]
+	r.mu.Unlock()
+	if ok && r.now().Before(entry.expires) {
+		return entry.info, entry.err
+	}
+
+	info, err := r.lookup(ctx, ip)
+	// a canceled lookup says nothing about the IP
+	if ctx.Err() != nil {
+		return info, err
+	}
+	ttl := podCacheTTL
+	if err != nil {
+		ttl = podNegativeCacheTTL
+	}
+	r.store(ip, podCacheEntry{info: info, err: err, expires: r.now().Add(ttl)})
+	return info, err
+}
+
+func (r *PodResolver) lookup(ctx context.Context, ip string) (PodInfo, error) {
+	pods := &corev1.PodList{}
+	if err := r.cl.List(ctx, pods, client.MatchingFields{"status.podIP": ip}); err != nil {
+		return PodInfo{}, fmt.Errorf("failed to list pods with IP %s: %w", ip, err)
+	}
+	var pod *corev1.Pod
+	for i := range pods.Items {
+		if pods.Items[i].Status.Phase == corev1.PodRunning && !pods.Items[i].Spec.HostNetwork {
+			pod = &pods.Items[i]
+			break
+		}
+	}
+	if pod == nil {
+		return PodInfo{}, fmt.Errorf("no running pod with IP %s", ip)
+	}
+
+	zone, err := GetNodeZone(ctx, r.cl, pod.Spec.NodeName)
+	if err != nil {
+		return PodInfo{}, err
+	}
+	return PodInfo{Name: pod.Name, Zone: zone}, nil
+}
+
+// store adds an entry, dropping expired entries first when the cache is full
+// and arbitrary ones if that is not enough
+func (r *PodResolver) store(ip string, entry podCacheEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.cache[ip]; !ok && len(r.cache) >= maxCachedPods {
+		now := r.now()
+		for k, e := range r.cache {
+			if !now.Before(e.expires) {
+				delete(r.cache, k)
+			}
+		}
+		for k := range r.cache {
+			if len(r.cache) < maxCachedPods {
+				break
+			}
+			delete(r.cache, k)
+		}
+	}
+	r.cache[ip] = entry
+}
@@ -0,0 +1,93 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// newCountingClient returns a fake client with one running pod on a node in
+// zone-a and a counter of the pod listings
+func newCountingClient(lists *int) client.Client {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "node-a",
+		Labels: map[string]string{corev1.LabelTopologyZone: "zone-a"},
+	}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "server-1", Namespace: "default"},
+		Spec:       corev1.PodSpec{NodeName: "node-a"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.0.0.1"},
+	}
+	return fake.NewClientBuilder().
+		WithScheme(clientgoscheme.Scheme).
+		WithObjects(node, pod).
+		WithIndex(&corev1.Pod{}, "status.podIP", func(o client.Object) []string {
+			return []string{o.(*corev1.Pod).Status.PodIP}
+		}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, cl client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				*lists++
+				return cl.List(ctx, list, opts...)
+			},
+		}).
+		Build()
+}
+
+func TestPodResolverCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		ip      string
+		want    PodInfo
+		wantErr bool
+		// lookups within the TTL must not list again
+		ttl time.Duration
+	}{
+		{name: "pod", ip: "10.0.0.1", want: PodInfo{Name: "server-1", Zone: "zone-a"}, ttl: podCacheTTL},
+		{name: "unknown IP", ip: "10.96.0.10", wantErr: true, ttl: podNegativeCacheTTL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lists := 0
+			r := NewPodResolver(newCountingClient(&lists))
+			now := time.Now()
+			r.now = func() time.Time { return now }
+
+			for range 3 {
+				got, err := r.Resolve(context.Background(), tt.ip)
+				if (err != nil) != tt.wantErr || got != tt.want {
+					t.Fatalf("got %+v, %v, want %+v, error %t", got, err, tt.want, tt.wantErr)
+				}
+			}
+			if lists != 1 {
+				t.Errorf("listed pods %d times within the TTL, want 1", lists)
+			}
+
+			now = now.Add(tt.ttl)
+			if _, err := r.Resolve(context.Background(), tt.ip); (err != nil) != tt.wantErr {
+				t.Fatalf("after the TTL: %v", err)
+			}
+			if lists != 2 {
+				t.Errorf("listed pods %d times after the TTL, want 2", lists)
+			}
+		})
+	}
+}
+
+func TestPodResolverCacheBounded(t *testing.T) {
+	lists := 0
+	r := NewPodResolver(newCountingClient(&lists))
+	for i := range maxCachedPods + 10 {
+		r.Resolve(context.Background(), fmt.Sprintf("192.168.%d.%d", i/256, i%256))
+	}
+	if len(r.cache) > maxCachedPods {
+		t.Errorf("cache holds %d entries, want at most %d", len(r.cache), maxCachedPods)
+	}
+}
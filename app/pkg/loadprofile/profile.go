@@ -0,0 +1,212 @@
+package loadprofile
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	TypeConstant = "constant"
+	TypeRamp     = "ramp"
+	TypeStep     = "step"
+	TypeSine     = "sine"
+	TypeBurst    = "burst"
+	TypeReplay   = "replay"
+)
+
+// Profile returns the request rate to use after elapsed time since start
+type Profile interface {
+	Rate(elapsed time.Duration) float64
+}
+
+// Config is the file format of a load profile. Only the fields of the
+// selected type are used, e.g.
+//
+//	type: sine
+//	min: 5
+//	max: 50
+//	period: 24h
+type Config struct {
+	Type string `yaml:"type"`
+	// Loop restarts ramp, step and replay profiles once they reach their end,
+	// otherwise the last rate is kept
+	Loop bool `yaml:"loop"`
+
+	// constant
+	Rate float64 `yaml:"rate"`
+
+	// ramp
+	From     float64       `yaml:"from"`
+	To       float64       `yaml:"to"`
+	Duration time.Duration `yaml:"duration"`
+
+	// step
+	Steps []Step `yaml:"steps"`
+
+	// sine, Phase shifts the curve so it can be aligned with a daily peak
+	Min    float64       `yaml:"min"`
+	Max    float64       `yaml:"max"`
+	Period time.Duration `yaml:"period"`
+	Phase  time.Duration `yaml:"phase"`
+
+	// burst, Peak is used for Length at the start of every Every interval
+	Base   float64       `yaml:"base"`
+	Peak   float64       `yaml:"peak"`
+	Every  time.Duration `yaml:"every"`
+	Length time.Duration `yaml:"length"`
+
+	// replay, relative paths are resolved against the profile file
+	File string `yaml:"file"`
+}
+
+type Step struct {
+	Duration time.Duration `yaml:"duration"`
+	Rate     float64       `yaml:"rate"`
+}
+
+func Load(path string) (Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing load profile %s: %w", path, err)
+	}
+	if cfg.File != "" && !filepath.IsAbs(cfg.File) {
+		cfg.File = filepath.Join(filepath.Dir(path), cfg.File)
+	}
+	return cfg.Build()
+}
+
+// Build validates the config and returns the matching profile
+func (c Config) Build() (Profile, error) {
+	switch c.Type {
+	case TypeConstant:
+		if c.Rate < 0 {
+			return nil, fmt.Errorf("constant rate cannot be negative")
+		}
+		return Constant(c.Rate), nil
+	case TypeRamp:
+		if c.From < 0 || c.To < 0 {
+			return nil, fmt.Errorf("ramp rates cannot be negative")
+		}
+		if c.Duration <= 0 {
+			return nil, fmt.Errorf("ramp duration must be positive")
+		}
+		return ramp{from: c.From, to: c.To, duration: c.Duration, loop: c.Loop}, nil
+	case TypeStep:
+		if len(c.Steps) == 0 {
+			return nil, fmt.Errorf("step profile needs at least one step")
+		}
+		for i, s := range c.Steps {
+			if s.Duration <= 0 || s.Rate < 0 {
+				return nil, fmt.Errorf("step %d needs a positive duration and a non negative rate", i)
+			}
+		}
+		return step{steps: c.Steps, loop: c.Loop}, nil
+	case TypeSine:
+		if c.Min < 0 || c.Max < c.Min {
+			return nil, fmt.Errorf("sine needs 0 <= min <= max")
+		}
+		if c.Period <= 0 {
+			return nil, fmt.Errorf("sine period must be positive")
+		}
+		return sine{min: c.Min, max: c.Max, period: c.Period, phase: c.Phase}, nil
+	case TypeBurst:
+		if c.Base < 0 || c.Peak < 0 {
+			return nil, fmt.Errorf("burst rates cannot be negative")
+		}
+		if c.Every <= 0 || c.Length <= 0 || c.Length > c.Every {
+			return nil, fmt.Errorf("burst needs 0 < length <= every")
+		}
+		return burst{base: c.Base, peak: c.Peak, every: c.Every, length: c.Length}, nil
+	case TypeReplay:
+		if c.File == "" {
+			return nil, fmt.Errorf("replay profile needs a file")
+		}
+		steps, err := loadReplay(c.File)
+		if err != nil {
+			return nil, err
+		}
+		return step{steps: steps, loop: c.Loop}, nil
+	default:
+		return nil, fmt.Errorf("unknown load profile type %q", c.Type)
+	}
+}
+
+// Constant always returns the same rate
+type Constant float64
+
+func (c Constant) Rate(time.Duration) float64 {
+	return float64(c)
+}
+
+type ramp struct {
+	from, to float64
+	duration time.Duration
+	loop     bool
+}
+
+func (r ramp) Rate(elapsed time.Duration) float64 {
+	if elapsed >= r.duration {
+		if !r.loop {
+			return r.to
+		}
+		elapsed %= r.duration
+	}
+	return r.from + (r.to-r.from)*float64(elapsed)/float64(r.duration)
+}
+
+type step struct {
+	steps []Step
+	loop  bool
+}
+
+func (s step) Rate(elapsed time.Duration) float64 {
+	var total time.Duration
+	for _, st := range s.steps {
+		total += st.Duration
+	}
+	if elapsed >= total {
+		if !s.loop {
+			return s.steps[len(s.steps)-1].Rate
+		}
+		elapsed %= total
+	}
+	for _, st := range s.steps {
+		if elapsed < st.Duration {
+			return st.Rate
+		}
+		elapsed -= st.Duration
+	}
+	return s.steps[len(s.steps)-1].Rate
+}
+
+// sine starts at min and peaks at max half way through the period
+type sine struct {
+	min, max      float64
+	period, phase time.Duration
+}
+
+func (s sine) Rate(elapsed time.Duration) float64 {
+	x := 2 * math.Pi * float64(elapsed+s.phase) / float64(s.period)
+	return s.min + (s.max-s.min)*(1-math.Cos(x))/2
+}
+
+type burst struct {
+	base, peak    float64
+	every, length time.Duration
+}
+
+func (b burst) Rate(elapsed time.Duration) float64 {
+	if elapsed%b.every < b.length {
+		return b.peak
+	}
+	return b.base
+}
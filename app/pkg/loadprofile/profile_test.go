@@ -0,0 +1,143 @@
+package loadprofile
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProfileRate(t *testing.T) {
+	steps := []Step{{Duration: 10 * time.Second, Rate: 5}, {Duration: 20 * time.Second, Rate: 50}}
+	tests := []struct {
+		name    string
+		cfg     Config
+		elapsed time.Duration
+		want    float64
+	}{
+		{name: "constant", cfg: Config{Type: TypeConstant, Rate: 7}, elapsed: time.Hour, want: 7},
+		{name: "ramp start", cfg: Config{Type: TypeRamp, From: 10, To: 20, Duration: time.Minute}, want: 10},
+		{name: "ramp half way", cfg: Config{Type: TypeRamp, From: 10, To: 20, Duration: time.Minute}, elapsed: 30 * time.Second, want: 15},
+		{name: "ramp end", cfg: Config{Type: TypeRamp, From: 10, To: 20, Duration: time.Minute}, elapsed: time.Hour, want: 20},
+		{name: "ramp loop", cfg: Config{Type: TypeRamp, From: 10, To: 20, Duration: time.Minute, Loop: true}, elapsed: 90 * time.Second, want: 15},
+		{name: "first step", cfg: Config{Type: TypeStep, Steps: steps}, elapsed: 9 * time.Second, want: 5},
+		{name: "second step", cfg: Config{Type: TypeStep, Steps: steps}, elapsed: 10 * time.Second, want: 50},
+		{name: "last step is kept", cfg: Config{Type: TypeStep, Steps: steps}, elapsed: time.Hour, want: 50},
+		{name: "step loop", cfg: Config{Type: TypeStep, Steps: steps, Loop: true}, elapsed: 35 * time.Second, want: 5},
+		{name: "sine start", cfg: Config{Type: TypeSine, Min: 5, Max: 50, Period: time.Hour}, want: 5},
+		{name: "sine peak", cfg: Config{Type: TypeSine, Min: 5, Max: 50, Period: time.Hour}, elapsed: 30 * time.Minute, want: 50},
+		{name: "sine phase", cfg: Config{Type: TypeSine, Min: 5, Max: 50, Period: time.Hour, Phase: 30 * time.Minute}, want: 50},
+		{name: "burst peak", cfg: Config{Type: TypeBurst, Base: 1, Peak: 100, Every: time.Minute, Length: 5 * time.Second}, elapsed: 61 * time.Second, want: 100},
+		{name: "burst base", cfg: Config{Type: TypeBurst, Base: 1, Peak: 100, Every: time.Minute, Length: 5 * time.Second}, elapsed: 30 * time.Second, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := tt.cfg.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := profile.Rate(tt.elapsed); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("got rate %v after %s, want %v", got, tt.elapsed, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{name: "negative constant", cfg: Config{Type: TypeConstant, Rate: -1}, wantErr: "cannot be negative"},
+		{name: "ramp without duration", cfg: Config{Type: TypeRamp, To: 10}, wantErr: "duration must be positive"},
+		{name: "no steps", cfg: Config{Type: TypeStep}, wantErr: "at least one step"},
+		{name: "empty step", cfg: Config{Type: TypeStep, Steps: []Step{{Rate: 1}}}, wantErr: "step 0"},
+		{name: "sine max below min", cfg: Config{Type: TypeSine, Min: 10, Max: 5, Period: time.Hour}, wantErr: "min <= max"},
+		{name: "burst longer than interval", cfg: Config{Type: TypeBurst, Every: time.Second, Length: time.Minute}, wantErr: "length <= every"},
+		{name: "replay without file", cfg: Config{Type: TypeReplay}, wantErr: "needs a file"},
+		{name: "unknown type", cfg: Config{Type: "wave"}, wantErr: "unknown load profile type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.cfg.Build()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error with %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadReplay(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    []Step
+		wantErr string
+	}{
+		{
+			name: "offsets with header and comments",
+			csv:  "time,rate\n# warm up\n0,5\n\n10s,20\n30,0\n",
+			want: []Step{{10 * time.Second, 5}, {20 * time.Second, 20}, {time.Second, 0}},
+		},
+		{
+			name: "timestamps are relative to the first row",
+			csv:  "2024-01-01T10:00:00Z,1\n2024-01-01T10:01:00Z,2\n",
+			want: []Step{{time.Minute, 1}, {time.Second, 2}},
+		},
+		{
+			name: "a late first row covers the start",
+			csv:  "5s,1\n15s,2\n",
+			want: []Step{{15 * time.Second, 1}, {time.Second, 2}},
+		},
+		{
+			name:    "line of a bad rate after comments",
+			csv:     "time,rate\n# warm up\n\n0,5\n10s,fast\n",
+			wantErr: ":5: invalid rate",
+		},
+		{
+			name:    "line of a decreasing time",
+			csv:     "# peak\n10s,1\n# oops\n5s,1\n",
+			wantErr: ":4: times must be increasing",
+		},
+		{
+			name:    "bad time",
+			csv:     "soon,1\n",
+			wantErr: `:1: invalid time "soon"`,
+		},
+		{
+			name:    "no rows",
+			csv:     "time,rate\n",
+			wantErr: "no rows",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rates.csv")
+			if err := os.WriteFile(path, []byte(tt.csv), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			steps, err := loadReplay(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error with %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(steps) != len(tt.want) {
+				t.Fatalf("got steps %v, want %v", steps, tt.want)
+			}
+			for i := range steps {
+				if steps[i] != tt.want[i] {
+					t.Errorf("got steps %v, want %v", steps, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
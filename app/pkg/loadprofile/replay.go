@@ -0,0 +1,106 @@
+package loadprofile
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loadReplay reads a CSV of "time,rate" rows. The time column is either an
+// offset from the start (a duration like 90s or a number of seconds) or an
+// RFC3339 timestamp, in which case it is taken relative to the first row.
+// Each rate is held until the time of the next row, the last one for one
+// second. A header row is skipped if its rate column is not a number.
+func loadReplay(path string) ([]Step, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+
+	type point struct {
+		at   time.Duration
+		rate float64
+	}
+	var points []point
+	var first time.Time
+	for header := true; ; header = false {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		// the line in the file, comments and blank lines are not records
+		line, _ := r.FieldPos(0)
+
+		rate, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil {
+			if header {
+				continue
+			}
+			return nil, fmt.Errorf("%s:%d: invalid rate %q", path, line, record[1])
+		}
+		if rate < 0 {
+			return nil, fmt.Errorf("%s:%d: rate cannot be negative", path, line)
+		}
+
+		at, ts, err := parseOffset(strings.TrimSpace(record[0]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if !ts.IsZero() {
+			if first.IsZero() {
+				first = ts
+			}
+			at = ts.Sub(first)
+		}
+		if len(points) > 0 && at <= points[len(points)-1].at {
+			return nil, fmt.Errorf("%s:%d: times must be increasing", path, line)
+		}
+		points = append(points, point{at: at, rate: rate})
+	}
+	if len(points) == 0 {
+		return nil, fmt.Errorf("%s: no rows", path)
+	}
+
+	// everything before the first row runs at its rate as well
+	steps := make([]Step, len(points))
+	for i, p := range points {
+		end := p.at + time.Second
+		if i+1 < len(points) {
+			end = points[i+1].at
+		}
+		start := p.at
+		if i == 0 {
+			start = 0
+		}
+		steps[i] = Step{Duration: end - start, Rate: p.rate}
+	}
+	return steps, nil
+}
+
+// parseOffset returns either an offset or an absolute timestamp
+func parseOffset(s string) (time.Duration, time.Time, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, time.Time{}, nil
+	}
+	if ts, err := time.Parse(time.RFC3339, s); err == nil {
+		return 0, ts, nil
+	}
+	return 0, time.Time{}, fmt.Errorf("invalid time %q, expected seconds, a duration or an RFC3339 timestamp", s)
+}
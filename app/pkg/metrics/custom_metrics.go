@@ -9,10 +9,26 @@ import (
 var trafficCounter = prometheus.NewCounterVec(
 	prometheus.CounterOpts{
 		Name: "traffic_total",
-		Help: "Total bytes sent and received by protocol, source pod, source az, target az, target pod, and success.",
+		Help: "Total body bytes sent and received as written on the connection, including any response envelope, by protocol, source pod, source az, target az, target pod, and success.",
 	},
 	[]string{"success", "protocol", "source_pod", "source_az", "target_az", "target_pod"})
 
+// trafficScale multiplies all reported traffic, set once at startup
+var trafficScale = 1.0
+
+var trafficScaleGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "traffic_scale_factor",
+		Help: "Factor traffic_total and client_traffic_total are multiplied by, divide by it to get the real bytes.",
+	})
+
+// SetTrafficScale makes traffic metrics report bytes times factor, for demo
+// environments where real volumes are too small to show up in cost reports
+func SetTrafficScale(factor float64) {
+	trafficScale = factor
+	trafficScaleGauge.Set(factor)
+}
+
 func TrackTraffic(bytes float64, success bool, protocol string, sourcePod string, sourceAz string, targetAz string, targetName string) {
 	trafficCounter.With(prometheus.Labels{
 		"success":    strconv.FormatBool(success),
@@ -21,5 +37,181 @@ func TrackTraffic(bytes float64, success bool, protocol string, sourcePod string
 		"source_az":  sourceAz,
 		"target_az":  targetAz,
 		"target_pod": targetName,
-	}).Add(bytes)
+	}).Add(bytes * trafficScale)
+}
+
+var memoryAllocatedGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "memory_allocated_bytes",
+		Help: "Bytes currently held by the memory pressure module by profile.",
+	},
+	[]string{"profile"})
+
+func SetMemoryAllocated(profile string, bytes float64) {
+	memoryAllocatedGauge.With(prometheus.Labels{"profile": profile}).Set(bytes)
+}
+
+var clientTargetRateGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "echo_client_target_requests_per_second",
+		Help: "Request rate the echo client is configured to send, by flow of targets sharing a rate.",
+	},
+	[]string{"flow"})
+
+var clientAchievedRateGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "echo_client_achieved_requests_per_second",
+		Help: "Requests completed by the echo client during the last second, by flow of targets sharing a rate.",
+	},
+	[]string{"flow"})
+
+var clientInFlightGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "echo_client_requests_in_flight",
+		Help: "Echo client requests currently waiting for the server.",
+	})
+
+func SetClientTargetRate(flow string, rps float64) {
+	clientTargetRateGauge.With(prometheus.Labels{"flow": flow}).Set(rps)
+}
+
+func SetClientAchievedRate(flow string, rps float64) {
+	clientAchievedRateGauge.With(prometheus.Labels{"flow": flow}).Set(rps)
+}
+
+func AddClientInFlight(delta float64) {
+	clientInFlightGauge.Add(delta)
+}
+
+var clientTrafficCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "client_traffic_total",
+		Help: "Bytes sent and received as observed by the echo client, same labels as traffic_total.",
+	},
+	[]string{"success", "protocol", "source_pod", "source_az", "target_az", "target_pod"})
+
+var clientRequestDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "echo_client_request_duration_seconds",
+		Help:    "Echo client request latency by source az, target az and status code.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+	},
+	[]string{"source_az", "target_az", "status_code"})
+
+var clientRequestsCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "echo_client_requests_total",
+		Help: "Echo client requests that got a response by source az, target az and status code.",
+	},
+	[]string{"source_az", "target_az", "status_code"})
+
+var clientErrorsCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "echo_client_transport_errors_total",
+		Help: "Echo client requests that failed without a response by source az, target az and reason.",
+	},
+	[]string{"source_az", "target_az", "reason"})
+
+// TrackClientTraffic is the client side counterpart of TrackTraffic, kept in a
+// separate metric so the two views can be compared without double counting
+func TrackClientTraffic(bytes float64, success bool, protocol string, sourcePod string, sourceAz string, targetAz string, targetName string) {
+	clientTrafficCounter.With(prometheus.Labels{
+		"success":    strconv.FormatBool(success),
+		"protocol":   protocol,
+		"source_pod": sourcePod,
+		"source_az":  sourceAz,
+		"target_az":  targetAz,
+		"target_pod": targetName,
+	}).Add(bytes * trafficScale)
+}
+
+func TrackClientRequest(sourceAz, targetAz string, statusCode int, seconds float64) {
+	labels := prometheus.Labels{
+		"source_az":   sourceAz,
+		"target_az":   targetAz,
+		"status_code": strconv.Itoa(statusCode),
+	}
+	clientRequestsCounter.With(labels).Inc()
+	clientRequestDuration.With(labels).Observe(seconds)
+}
+
+func TrackClientError(sourceAz, targetAz, reason string) {
+	clientErrorsCounter.With(prometheus.Labels{
+		"source_az": sourceAz,
+		"target_az": targetAz,
+		"reason":    reason,
+	}).Inc()
+}
+
+var clientRetriesCounter = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "echo_client_retries_total",
+		Help: "Echo client requests retried after a transport error.",
+	})
+
+var clientCircuitStateGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "echo_client_circuit_state",
+		Help: "Circuit breaker state per target, 0 closed, 1 half open, 2 open.",
+	},
+	[]string{"target"})
+
+func IncClientRetries() {
+	clientRetriesCounter.Inc()
+}
+
+func SetClientCircuitState(target string, state float64) {
+	clientCircuitStateGauge.With(prometheus.Labels{"target": target}).Set(state)
+}
+
+// DeleteClientCircuitState drops the series of a target that is gone
+func DeleteClientCircuitState(target string) {
+	clientCircuitStateGauge.Delete(prometheus.Labels{"target": target})
+}
+
+var clientConnectionsCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "echo_client_connections_total",
+		Help: "Connections used by echo client requests, reused is false for newly opened ones.",
+	},
+	[]string{"reused"})
+
+func TrackClientConnection(reused bool) {
+	clientConnectionsCounter.With(prometheus.Labels{"reused": strconv.FormatBool(reused)}).Inc()
+}
+
+var zoneConfigVersionGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "zone_config_version",
+		Help: "Version of the active zone config, incremented on every reload and fault scenario phase, labelled with the file hash or admin when set through the admin API.",
+	},
+	[]string{"hash"})
+
+var zoneConfigReloadsCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "zone_config_reloads_total",
+		Help: "Zone config reload attempts by success.",
+	},
+	[]string{"success"})
+
+func SetZoneConfigVersion(version float64, hash string) {
+	// only the active hash is exported
+	zoneConfigVersionGauge.Reset()
+	zoneConfigVersionGauge.With(prometheus.Labels{"hash": hash}).Set(version)
+}
+
+func TrackZoneConfigReload(success bool) {
+	zoneConfigReloadsCounter.With(prometheus.Labels{"success": strconv.FormatBool(success)}).Inc()
+}
+
+var scenarioPhaseGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "fault_scenario_phase",
+		Help: "Set to 1 for the active fault scenario phase, the phase label is empty when none is active.",
+	},
+	[]string{"phase"})
+
+func SetScenarioPhase(phase string) {
+	scenarioPhaseGauge.Reset()
+	scenarioPhaseGauge.With(prometheus.Labels{"phase": phase}).Set(1)
 }
@@ -2,4 +2,19 @@ package metrics
 
 func RegisterCustomMetrics() {
 	registry.MustRegister(trafficCounter)
+	registry.MustRegister(trafficScaleGauge)
+	registry.MustRegister(memoryAllocatedGauge)
+	registry.MustRegister(clientTargetRateGauge)
+	registry.MustRegister(clientAchievedRateGauge)
+	registry.MustRegister(clientInFlightGauge)
+	registry.MustRegister(clientTrafficCounter)
+	registry.MustRegister(clientRequestDuration)
+	registry.MustRegister(clientRequestsCounter)
+	registry.MustRegister(clientErrorsCounter)
+	registry.MustRegister(clientRetriesCounter)
+	registry.MustRegister(clientCircuitStateGauge)
+	registry.MustRegister(clientConnectionsCounter)
+	registry.MustRegister(zoneConfigVersionGauge)
+	registry.MustRegister(zoneConfigReloadsCounter)
+	registry.MustRegister(scenarioPhaseGauge)
 }
@@ -0,0 +1,108 @@
+package module
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/Tsonov/cast-taler/app/pkg/server"
+)
+
+// Module is a unit of work the app binary can run, selected with --module.
+// Modules register themselves from an init function so that main only needs
+// to import the package.
+type Module interface {
+	// Name is the value passed to --module.
+	Name() string
+	// Description is a one line summary shown by --list-modules.
+	Description() string
+	// Flags returns the module specific flags. They are merged into the
+	// command line flags before parsing. Modules sharing a flag set may
+	// return the same instance.
+	Flags() *pflag.FlagSet
+	// Run blocks until the module finishes or ctx is cancelled.
+	Run(ctx context.Context, env Env) error
+}
+
+// ReadinessReporter is implemented by modules that drive the kubernetes
+// readiness probe. The readiness server is only started when one of the
+// selected modules implements it.
+type ReadinessReporter interface {
+	ReportsReadiness()
+}
+
+// Env carries the process wide state shared by all modules.
+type Env struct {
+	Logger           *slog.Logger
+	AvailabilityZone string
+	PodName          string
+	NodeName         string
+	ZoneConfig       *server.ZoneConfigStore
+	Ready            *atomic.Bool
+	// K8sClient is nil when the binary runs outside of a cluster
+	K8sClient client.WithWatch
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]Module{}
+)
+
+// Register adds a module to the registry. It panics on duplicate names since
+// that is always a programming error.
+func Register(m Module) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := registry[m.Name()]; ok {
+		panic(fmt.Sprintf("module %q registered twice", m.Name()))
+	}
+	registry[m.Name()] = m
+}
+
+// Get returns the module registered under name.
+func Get(name string) (Module, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	m, ok := registry[name]
+	return m, ok
+}
+
+// List returns all registered modules sorted by name.
+func List() []Module {
+	mu.RLock()
+	defer mu.RUnlock()
+	out := make([]Module, 0, len(registry))
+	for _, m := range registry {
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out
+}
+
+// Resolve looks up all names and fails on the first unknown one.
+func Resolve(names []string) ([]Module, error) {
+	out := make([]Module, 0, len(names))
+	for _, name := range names {
+		m, ok := Get(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown module %q, use --list-modules to see the available ones", name)
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// AddFlags merges the flags of every registered module into fs.
+func AddFlags(fs *pflag.FlagSet) {
+	for _, m := range List() {
+		if f := m.Flags(); f != nil {
+			fs.AddFlagSet(f)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package module
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+type testModule struct {
+	name  string
+	flags *pflag.FlagSet
+}
+
+func (m testModule) Name() string                   { return m.name }
+func (m testModule) Description() string            { return "test module " + m.name }
+func (m testModule) Flags() *pflag.FlagSet          { return m.flags }
+func (m testModule) Run(context.Context, Env) error { return nil }
+
+// register adds a module to the global registry until the test ends
+func register(t *testing.T, m Module) {
+	t.Helper()
+	Register(m)
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(registry, m.Name())
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	shared := pflag.NewFlagSet("shared", pflag.ContinueOnError)
+	shared.Int("test-port", 1, "")
+	register(t, testModule{name: "test-b", flags: shared})
+	register(t, testModule{name: "test-a", flags: shared})
+	register(t, testModule{name: "test-c"})
+
+	var names []string
+	for _, m := range List() {
+		if strings.HasPrefix(m.Name(), "test-") {
+			names = append(names, m.Name())
+		}
+	}
+	if got := strings.Join(names, ","); got != "test-a,test-b,test-c" {
+		t.Errorf("got modules %s, want them sorted by name", got)
+	}
+
+	tests := []struct {
+		names   []string
+		wantErr string
+	}{
+		{names: []string{"test-a", "test-c"}},
+		{names: nil},
+		{names: []string{"test-a", "test-x"}, wantErr: `unknown module "test-x"`},
+	}
+	for _, tt := range tests {
+		modules, err := Resolve(tt.names)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Resolve(%v): got %v, want an error with %q", tt.names, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || len(modules) != len(tt.names) {
+			t.Errorf("Resolve(%v): got %d modules, %v", tt.names, len(modules), err)
+		}
+	}
+
+	// modules sharing a flag set and modules without flags are fine
+	fs := pflag.NewFlagSet("app", pflag.ContinueOnError)
+	AddFlags(fs)
+	if fs.Lookup("test-port") == nil {
+		t.Error("module flags were not added")
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	register(t, testModule{name: "test-twice"})
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice did not panic")
+		}
+	}()
+	Register(testModule{name: "test-twice"})
+}
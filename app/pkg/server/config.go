@@ -1,19 +1,272 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// Pseudo status codes for failures that never produce an HTTP response
+const (
+	// CodeReset aborts the connection with a TCP RST
+	CodeReset = -1
+	// CodeClose closes the connection without writing a response
+	CodeClose = -2
+	// CodeBlackhole holds the request without ever answering it, until the
+	// client gives up, blackhole-for passes or the server shuts down
+	CodeBlackhole = -3
+)
+
+var pseudoCodes = map[string]int{
+	"reset":     CodeReset,
+	"close":     CodeClose,
+	"blackhole": CodeBlackhole,
+}
+
+// ZoneConfig maps zone keys to their behaviour, see Match for how keys are
+// matched against zone names
 type ZoneConfig map[string]Zone
 
+// Zone maps status codes to relative weights, e.g.
+//
+//	b:
+//	  200: 60
+//	  429: 20
+//	  reset: 10
+//	  close: 10
+//	  blackhole: 5
+//	  retry-after: 5s
+//	  blackhole-for: 1m
+//
+// Weights do not need to sum to 100, each code is picked with weight/total.
+//
+// A zone can override its behaviour for callers from specific client zones,
+// e.g. to fail 30% of the cross zone calls from a:
+//
+//	c:
+//	  200: 100
+//	  from:
+//	    a:
+//	      200: 70
+//	      500: 30
+//
+// An override replaces the weights if it has any, latency, retry-after and
+// blackhole-for are inherited from the zone unless the override sets them.
 type Zone struct {
-	R200 int `yaml:"200"`
-	R404 int `yaml:"404"`
-	R500 int `yaml:"500"`
+	// Line is where the zone starts in the config file, used in validation errors
+	Line    int
+	Weights map[int]int
+	// RetryAfter is sent with 429 and 503 responses when set
+	RetryAfter time.Duration
+	// BlackholeFor caps how long a blackholed request is held before its
+	// connection is dropped, the server default applies when unset
+	BlackholeFor time.Duration
+	// Latency is optional, zones without it respond right away
+	Latency *Latency
+	// From maps client zones to overrides for requests coming from them
+	From map[string]Zone
+
+	// lines of the individual entries, used in validation errors
+	codeLines        map[int]int
+	retryAfterLine   int
+	blackholeForLine int
+	latencyLine      int
+	// overlay marks entries of a scenario phase, they are matched first
+	overlay bool
+}
+
+// UnmarshalYAML decodes every zone on its own, so all broken zones of a file
+// are reported at once as ValidationErrors
+func (z *ZoneConfig) UnmarshalYAML(node *yaml.Node) error {
+	zones, errs := decodeZones(node, "")
+	*z = zones
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// decodeZones decodes a mapping of zone keys to zones, collecting the errors
+// of all of them. Errors name the zone as prefix followed by its key.
+func decodeZones(node *yaml.Node, prefix string) (map[string]Zone, ValidationErrors) {
+	if node.Kind != yaml.MappingNode {
+		return nil, ValidationErrors{{Line: node.Line, Zone: strings.TrimSpace(prefix), Message: "expected a mapping of zone keys to zones"}}
+	}
+	zones := map[string]Zone{}
+	seen := map[string]bool{}
+	var errs ValidationErrors
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		name := prefix + key.Value
+		if seen[key.Value] {
+			errs = append(errs, ValidationError{Line: key.Line, Zone: name, Message: "duplicate zone key"})
+			continue
+		}
+		seen[key.Value] = true
+		// a key without a value, the zone would have no line of its own
+		if value.Tag == "!!null" {
+			errs = append(errs, ValidationError{Line: key.Line, Zone: name, Message: "zone is empty, expected status codes and weights"})
+			continue
+		}
+		var zone Zone
+		if err := value.Decode(&zone); err != nil {
+			var zoneErrs ValidationErrors
+			if !errors.As(err, &zoneErrs) {
+				zoneErrs = ValidationErrors{{Line: value.Line, Message: err.Error()}}
+			}
+			for _, e := range zoneErrs {
+				// errors of from overrides already name the client zone
+				e.Zone = strings.TrimSpace(name + " " + e.Zone)
+				errs = append(errs, e)
+			}
+			// check what did decode too, weights may be missing because of the
+			// broken codes, so they are not required
+			errs = append(errs, zone.validateWithOverrides(name, true)...)
+			continue
+		}
+		zones[key.Value] = zone
+	}
+	return zones, errs
+}
+
+// UnmarshalYAML reads status codes, their weights and the other zone settings.
+// Every problem is returned as a ValidationErrors without the zone name, the
+// caller knows the key of the zone.
+func (z *Zone) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return ValidationErrors{{Line: node.Line, Message: "zone must be a mapping of status codes to weights"}}
+	}
+	z.Line = node.Line
+	z.Weights = map[int]int{}
+	z.codeLines = map[int]int{}
+	var errs ValidationErrors
+	fail := func(line int, format string, args ...any) {
+		errs = append(errs, ValidationError{Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		switch key.Value {
+		case "latency":
+			if unknown := checkKnownKeys(value, latencyKeys); len(unknown) > 0 {
+				errs = append(errs, unknown...)
+				continue
+			}
+			z.Latency = &Latency{}
+			if err := value.Decode(z.Latency); err != nil {
+				fail(key.Line, "invalid latency: %s", yamlMessage(err))
+				z.Latency = nil
+			}
+			z.latencyLine = key.Line
+		case "retry-after":
+			if err := value.Decode(&z.RetryAfter); err != nil {
+				fail(key.Line, "invalid retry-after %q, expected a duration like 5s", value.Value)
+			}
+			z.retryAfterLine = key.Line
+		case "blackhole-for":
+			if err := value.Decode(&z.BlackholeFor); err != nil {
+				fail(key.Line, "invalid blackhole-for %q, expected a duration like 1m", value.Value)
+			}
+			z.blackholeForLine = key.Line
+		case "from":
+			from, fromErrs := decodeZones(value, "from ")
+			errs = append(errs, fromErrs...)
+			for client, override := range from {
+				if override.From != nil {
+					fail(override.Line, "override for client zone %s cannot have its own from", client)
+					delete(from, client)
+				}
+			}
+			z.From = from
+		default:
+			code, err := parseCode(key.Value)
+			if err != nil {
+				fail(key.Line, "%v", err)
+				continue
+			}
+			if _, ok := z.Weights[code]; ok {
+				fail(key.Line, "duplicate status code %s", key.Value)
+				continue
+			}
+			var weight int
+			if err := value.Decode(&weight); err != nil {
+				fail(key.Line, "weight of %s must be a whole number, got %q", key.Value, value.Value)
+				continue
+			}
+			z.Weights[code] = weight
+			z.codeLines[code] = key.Line
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// yamlMessage strips the line prefix of yaml.v3 type errors, validation errors
+// carry their own line
+func yamlMessage(err error) string {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return err.Error()
+	}
+	msgs := make([]string, len(typeErr.Errors))
+	for i, msg := range typeErr.Errors {
+		if _, rest, ok := strings.Cut(msg, ": "); ok && strings.HasPrefix(msg, "line ") {
+			msg = rest
+		}
+		msgs[i] = msg
+	}
+	return strings.Join(msgs, ", ")
+}
+
+func parseCode(s string) (int, error) {
+	if code, ok := pseudoCodes[s]; ok {
+		return code, nil
+	}
+	code, err := strconv.Atoi(s)
+	if err != nil || code < 200 || code > 599 {
+		return 0, fmt.Errorf("invalid status code %q, expected 200-599, reset, close or blackhole", s)
+	}
+	return code, nil
+}
+
+// CodeName returns the config key of a status or pseudo code
+func CodeName(code int) string {
+	for name, c := range pseudoCodes {
+		if c == code {
+			return name
+		}
+	}
+	return strconv.Itoa(code)
+}
+
+// MarshalYAML writes the zone in the format UnmarshalYAML reads, so a config
+// can be read back from a running server and edited
+func (z Zone) MarshalYAML() (interface{}, error) {
+	out := map[string]interface{}{}
+	for code, weight := range z.Weights {
+		out[CodeName(code)] = weight
+	}
+	if z.RetryAfter > 0 {
+		out["retry-after"] = z.RetryAfter.String()
+	}
+	if z.BlackholeFor > 0 {
+		out["blackhole-for"] = z.BlackholeFor.String()
+	}
+	if z.Latency != nil {
+		out["latency"] = z.Latency
+	}
+	if len(z.From) > 0 {
+		out["from"] = z.From
+	}
+	return out, nil
 }
 
 func LoadZoneConfig(path string) (*ZoneConfig, error) {
@@ -21,41 +274,113 @@ func LoadZoneConfig(path string) (*ZoneConfig, error) {
 	if err != nil {
 		return nil, err
 	}
+	cfg, err := ParseZoneConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseZoneConfig parses and validates a zone config. Zones that fail to
+// decode are reported together with the validation errors of the others.
+func ParseZoneConfig(data []byte) (*ZoneConfig, error) {
 	var cfg ZoneConfig
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	var errs ValidationErrors
+	if err := yaml.Unmarshal(data, &cfg); err != nil && !errors.As(err, &errs) {
 		return nil, err
 	}
+	if err := cfg.Validate(); err != nil {
+		var validateErrs ValidationErrors
+		if !errors.As(err, &validateErrs) {
+			return nil, err
+		}
+		errs = append(errs, validateErrs...)
+	}
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+		return nil, errs
+	}
 	return &cfg, nil
 }
 
-func (z ZoneConfig) CheckResponsePercentage() error {
-	for name, zone := range z {
-		total := zone.R200 + zone.R404 + zone.R500
-		if zone.R200 < 0 || zone.R404 < 0 || zone.R500 < 0 {
-			return fmt.Errorf("response counts cannot be negative for zone %s", name)
-		}
-		if total != 100 {
-			return fmt.Errorf("response percentages must sum to 100 for zone %s, got %d", name, total)
-		}
+func (z ZoneConfig) HasZone(zoneName string) bool {
+	_, ok := z.Match(zoneName)
+	return ok
+}
+
+// behaviour returns the zone entry that applies to a request from clientZone
+// to serverZone, both given as zone names
+func (z ZoneConfig) behaviour(serverZone, clientZone string) (Zone, bool) {
+	key, ok := z.Match(serverZone)
+	if !ok {
+		return Zone{}, false
 	}
-	return nil
+	zone := z[key]
+	clientKey, ok := matchKey(zone.From, clientZone)
+	if !ok {
+		return zone, true
+	}
+	override := zone.From[clientKey]
+	if len(override.Weights) == 0 {
+		override.Weights = zone.Weights
+	}
+	if override.Latency == nil {
+		override.Latency = zone.Latency
+	}
+	if override.RetryAfter == 0 {
+		override.RetryAfter = zone.RetryAfter
+	}
+	if override.BlackholeFor == 0 {
+		override.BlackholeFor = zone.BlackholeFor
+	}
+	return override, true
 }
 
-func (z ZoneConfig) GetRandomCode(zoneName string) (int, error) {
-	zone, ok := z[zoneName]
+func (z ZoneConfig) GetRandomCode(serverZone, clientZone string) (int, error) {
+	zone, ok := z.behaviour(serverZone, clientZone)
 	if !ok {
 		return 200, nil
 	}
-	total := zone.R200 + zone.R404 + zone.R500
+	// iterate in a fixed order so a seeded rand gives the same sequence
+	codes := make([]int, 0, len(zone.Weights))
+	total := 0
+	for code, weight := range zone.Weights {
+		codes = append(codes, code)
+		total += weight
+	}
 	if total == 0 {
-		return 0, fmt.Errorf("no responses defined for zone %s", zoneName)
+		return 0, fmt.Errorf("no responses defined for zone %s", serverZone)
 	}
+	sort.Ints(codes)
+
 	randNum := rand.Intn(total)
-	if randNum < zone.R200 {
-		return 200, nil
-	} else if randNum < zone.R200+zone.R404 {
-		return 404, nil
-	} else {
-		return 500, nil
+	for _, code := range codes {
+		if randNum < zone.Weights[code] {
+			return code, nil
+		}
+		randNum -= zone.Weights[code]
+	}
+	return codes[len(codes)-1], nil
+}
+
+// GetRetryAfter returns the Retry-After to send with a 429 or 503 from the zone
+func (z ZoneConfig) GetRetryAfter(serverZone, clientZone string) time.Duration {
+	zone, _ := z.behaviour(serverZone, clientZone)
+	return zone.RetryAfter
+}
+
+// GetBlackholeFor returns how long to hold a blackholed request, zero when the
+// zone leaves it to the server default
+func (z ZoneConfig) GetBlackholeFor(serverZone, clientZone string) time.Duration {
+	zone, _ := z.behaviour(serverZone, clientZone)
+	return zone.BlackholeFor
+}
+
+// GetLatency returns the delay to add before responding, zero for unknown zones
+func (z ZoneConfig) GetLatency(serverZone, clientZone string) time.Duration {
+	zone, ok := z.behaviour(serverZone, clientZone)
+	if !ok {
+		return 0
 	}
+	return zone.Latency.Sample()
 }
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const overridesConfig = `
+c:
+  200: 100
+  retry-after: 5s
+  latency: {type: fixed, value: 10ms}
+  from:
+    a:
+      200: 70
+      503: 30
+    b:
+      latency: {type: fixed, value: 50ms}
+    "region:eu-west-1":
+      retry-after: 1s
+default:
+  reset: 1
+  close: 1
+  blackhole: 1
+  blackhole-for: 30s
+`
+
+func TestParseZoneConfig(t *testing.T) {
+	cfg := mustParse(t, overridesConfig)
+	tests := []struct {
+		name                   string
+		serverZone, clientZone string
+		// wantCodes are the only codes the pair may get
+		wantCodes        []int
+		wantRetryAfter   time.Duration
+		wantBlackholeFor time.Duration
+		wantLatency      time.Duration
+	}{
+		{
+			name:       "zone without override",
+			serverZone: "us-east1-c", clientZone: "us-east1-c",
+			wantCodes:      []int{200},
+			wantRetryAfter: 5 * time.Second, wantLatency: 10 * time.Millisecond,
+		},
+		{
+			name:       "override replaces the weights",
+			serverZone: "us-east1-c", clientZone: "us-east1-a",
+			wantCodes:      []int{200, 503},
+			wantRetryAfter: 5 * time.Second, wantLatency: 10 * time.Millisecond,
+		},
+		{
+			name:       "override without weights inherits them",
+			serverZone: "us-east1-c", clientZone: "us-east1-b",
+			wantCodes:      []int{200},
+			wantRetryAfter: 5 * time.Second, wantLatency: 50 * time.Millisecond,
+		},
+		{
+			name:       "override by client region",
+			serverZone: "us-east1-c", clientZone: "eu-west-1z",
+			wantCodes:      []int{200},
+			wantRetryAfter: time.Second, wantLatency: 10 * time.Millisecond,
+		},
+		{
+			name:       "pseudo codes",
+			serverZone: "us-east1-d", clientZone: "us-east1-a",
+			wantCodes:        []int{CodeReset, CodeClose, CodeBlackhole},
+			wantBlackholeFor: 30 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := map[int]bool{}
+			for range 1000 {
+				code, err := cfg.GetRandomCode(tt.serverZone, tt.clientZone)
+				if err != nil {
+					t.Fatal(err)
+				}
+				seen[code] = true
+			}
+			if len(seen) != len(tt.wantCodes) {
+				t.Errorf("got codes %v, want %v", seen, tt.wantCodes)
+			}
+			for _, code := range tt.wantCodes {
+				if !seen[code] {
+					t.Errorf("never got %s, got %v", CodeName(code), seen)
+				}
+			}
+			if got := cfg.GetRetryAfter(tt.serverZone, tt.clientZone); got != tt.wantRetryAfter {
+				t.Errorf("got retry-after %s, want %s", got, tt.wantRetryAfter)
+			}
+			if got := cfg.GetBlackholeFor(tt.serverZone, tt.clientZone); got != tt.wantBlackholeFor {
+				t.Errorf("got blackhole-for %s, want %s", got, tt.wantBlackholeFor)
+			}
+			if got := cfg.GetLatency(tt.serverZone, tt.clientZone); got != tt.wantLatency {
+				t.Errorf("got latency %s, want %s", got, tt.wantLatency)
+			}
+		})
+	}
+}
+
+func TestZoneConfigRoundTrip(t *testing.T) {
+	cfg := mustParse(t, overridesConfig)
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := mustParse(t, string(data))
+	if diff := Diff(*cfg, *again); len(diff) > 0 {
+		t.Errorf("config changed after marshaling it:\n%s\n%v", data, diff)
+	}
+}
+
+func TestUnknownZoneIsHealthy(t *testing.T) {
+	cfg := mustParse(t, "us-east1-b: {500: 1}")
+	if code, err := cfg.GetRandomCode("eu-west-1a", ""); err != nil || code != 200 {
+		t.Errorf("got %d, %v for a zone without entry, want 200", code, err)
+	}
+	if cfg.HasZone("eu-west-1a") {
+		t.Error("zone without entry is reported as configured")
+	}
+}
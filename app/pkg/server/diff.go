@@ -0,0 +1,146 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Diff describes what changed between two zone configs, one line per change
+func Diff(old, new ZoneConfig) []string {
+	names := map[string]struct{}{}
+	for name := range old {
+		names[name] = struct{}{}
+	}
+	for name := range new {
+		names[name] = struct{}{}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var changes []string
+	for _, name := range sorted {
+		o, inOld := old[name]
+		n, inNew := new[name]
+		switch {
+		case !inOld:
+			changes = append(changes, fmt.Sprintf("zone %s added: %s", name, n.describe()))
+		case !inNew:
+			changes = append(changes, fmt.Sprintf("zone %s removed", name))
+		default:
+			for _, c := range diffZone(o, n) {
+				changes = append(changes, fmt.Sprintf("zone %s: %s", name, c))
+			}
+		}
+	}
+	return changes
+}
+
+func diffZone(old, new Zone) []string {
+	codes := map[int]struct{}{}
+	for code := range old.Weights {
+		codes[code] = struct{}{}
+	}
+	for code := range new.Weights {
+		codes[code] = struct{}{}
+	}
+	sorted := make([]int, 0, len(codes))
+	for code := range codes {
+		sorted = append(sorted, code)
+	}
+	sort.Ints(sorted)
+
+	var changes []string
+	for _, code := range sorted {
+		if o, n := old.Weights[code], new.Weights[code]; o != n {
+			changes = append(changes, fmt.Sprintf("%s %d -> %d", CodeName(code), o, n))
+		}
+	}
+	if old.RetryAfter != new.RetryAfter {
+		changes = append(changes, fmt.Sprintf("retry-after %s -> %s", old.RetryAfter, new.RetryAfter))
+	}
+	if old.BlackholeFor != new.BlackholeFor {
+		changes = append(changes, fmt.Sprintf("blackhole-for %s -> %s", old.BlackholeFor, new.BlackholeFor))
+	}
+	if o, n := old.Latency.describe(), new.Latency.describe(); o != n {
+		changes = append(changes, fmt.Sprintf("latency %s -> %s", o, n))
+	}
+
+	clients := map[string]struct{}{}
+	for client := range old.From {
+		clients[client] = struct{}{}
+	}
+	for client := range new.From {
+		clients[client] = struct{}{}
+	}
+	sortedClients := make([]string, 0, len(clients))
+	for client := range clients {
+		sortedClients = append(sortedClients, client)
+	}
+	sort.Strings(sortedClients)
+	for _, client := range sortedClients {
+		o, inOld := old.From[client]
+		n, inNew := new.From[client]
+		switch {
+		case !inOld:
+			changes = append(changes, fmt.Sprintf("from %s added: %s", client, n.describe()))
+		case !inNew:
+			changes = append(changes, fmt.Sprintf("from %s removed", client))
+		default:
+			for _, c := range diffZone(o, n) {
+				changes = append(changes, fmt.Sprintf("from %s: %s", client, c))
+			}
+		}
+	}
+	return changes
+}
+
+func (z Zone) describe() string {
+	codes := make([]int, 0, len(z.Weights))
+	for code := range z.Weights {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	out := ""
+	for _, code := range codes {
+		out += fmt.Sprintf("%s=%d ", CodeName(code), z.Weights[code])
+	}
+	if z.RetryAfter != 0 {
+		out += fmt.Sprintf("retry-after=%s ", z.RetryAfter)
+	}
+	if z.BlackholeFor != 0 {
+		out += fmt.Sprintf("blackhole-for=%s ", z.BlackholeFor)
+	}
+	return out + "latency=" + z.Latency.describe()
+}
+
+func (l *Latency) describe() string {
+	if l == nil {
+		return "none"
+	}
+	switch l.Type {
+	case LatencyFixed:
+		return fmt.Sprintf("fixed(%s)", l.Value)
+	case LatencyUniform:
+		return fmt.Sprintf("uniform(%s, %s)", l.Min, l.Max)
+	case LatencyNormal:
+		return fmt.Sprintf("normal(%s, %s)", l.Mean, l.StdDev)
+	case LatencyPercentiles:
+		points, err := l.percentilePoints()
+		if err != nil {
+			return "percentiles(invalid)"
+		}
+		out := "percentiles("
+		for i, p := range points {
+			if i > 0 {
+				out += ", "
+			}
+			out += fmt.Sprintf("p%g=%s", p.percentile, p.latency.Round(time.Microsecond))
+		}
+		return out + ")"
+	}
+	return l.Type
+}
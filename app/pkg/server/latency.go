@@ -0,0 +1,168 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	LatencyFixed       = "fixed"
+	LatencyUniform     = "uniform"
+	LatencyNormal      = "normal"
+	LatencyPercentiles = "percentiles"
+)
+
+// Latency describes the delay added before a zone responds, e.g.
+//
+//	latency:
+//	  type: percentiles
+//	  percentiles:
+//	    50: 20ms
+//	    99: 500ms
+type Latency struct {
+	Type string `yaml:"type"`
+
+	// fixed
+	Value time.Duration `yaml:"value"`
+
+	// uniform
+	Min time.Duration `yaml:"min"`
+	Max time.Duration `yaml:"max"`
+
+	// normal, samples below zero are clamped to zero
+	Mean   time.Duration `yaml:"mean"`
+	StdDev time.Duration `yaml:"stddev"`
+
+	// percentiles maps a percentile in (0, 100] to the latency at it, values
+	// between the points are interpolated linearly
+	Percentiles map[string]time.Duration `yaml:"percentiles"`
+
+	// points are the parsed and sorted percentiles, set by Validate so
+	// Sample does not parse them for every request
+	points []percentilePoint
+}
+
+// MarshalYAML writes durations as strings and leaves out unset fields
+func (l *Latency) MarshalYAML() (interface{}, error) {
+	out := map[string]interface{}{"type": l.Type}
+	for key, d := range map[string]time.Duration{
+		"value":  l.Value,
+		"min":    l.Min,
+		"max":    l.Max,
+		"mean":   l.Mean,
+		"stddev": l.StdDev,
+	} {
+		if d != 0 {
+			out[key] = d.String()
+		}
+	}
+	if len(l.Percentiles) > 0 {
+		percentiles := map[string]string{}
+		for p, d := range l.Percentiles {
+			percentiles[p] = d.String()
+		}
+		out["percentiles"] = percentiles
+	}
+	return out, nil
+}
+
+type percentilePoint struct {
+	percentile float64
+	latency    time.Duration
+}
+
+func (l *Latency) Validate() error {
+	if l == nil {
+		return nil
+	}
+	switch l.Type {
+	case LatencyFixed:
+		if l.Value < 0 {
+			return fmt.Errorf("fixed latency cannot be negative")
+		}
+	case LatencyUniform:
+		if l.Min < 0 || l.Max < l.Min {
+			return fmt.Errorf("uniform latency needs 0 <= min <= max")
+		}
+	case LatencyNormal:
+		if l.Mean < 0 || l.StdDev < 0 {
+			return fmt.Errorf("normal latency mean and stddev cannot be negative")
+		}
+	case LatencyPercentiles:
+		points, err := l.percentilePoints()
+		if err != nil {
+			return err
+		}
+		if len(points) == 0 {
+			return fmt.Errorf("percentiles latency needs at least one percentile")
+		}
+		l.points = points
+	default:
+		return fmt.Errorf("unknown latency type %q", l.Type)
+	}
+	return nil
+}
+
+// Sample draws one delay from the distribution. A nil latency means no delay,
+// percentiles only apply once the latency passed Validate.
+func (l *Latency) Sample() time.Duration {
+	if l == nil {
+		return 0
+	}
+	switch l.Type {
+	case LatencyFixed:
+		return l.Value
+	case LatencyUniform:
+		return l.Min + time.Duration(rand.Int63n(int64(l.Max-l.Min)+1))
+	case LatencyNormal:
+		d := float64(l.Mean) + rand.NormFloat64()*float64(l.StdDev)
+		return time.Duration(math.Max(d, 0))
+	case LatencyPercentiles:
+		if len(l.points) == 0 {
+			return 0
+		}
+		return interpolate(l.points, rand.Float64()*100)
+	}
+	return 0
+}
+
+func (l *Latency) percentilePoints() ([]percentilePoint, error) {
+	points := make([]percentilePoint, 0, len(l.Percentiles))
+	for key, latency := range l.Percentiles {
+		p, err := strconv.ParseFloat(key, 64)
+		if err != nil || p <= 0 || p > 100 {
+			return nil, fmt.Errorf("invalid percentile %q, must be a number in (0, 100]", key)
+		}
+		if latency < 0 {
+			return nil, fmt.Errorf("latency at percentile %s cannot be negative", key)
+		}
+		points = append(points, percentilePoint{percentile: p, latency: latency})
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].percentile < points[j].percentile })
+	for i := 1; i < len(points); i++ {
+		if points[i].latency < points[i-1].latency {
+			return nil, fmt.Errorf("latency must not decrease with the percentile, p%g is lower than p%g", points[i].percentile, points[i-1].percentile)
+		}
+	}
+	return points, nil
+}
+
+// interpolate returns the latency at percentile p, everything below the first
+// point gets the first latency
+func interpolate(points []percentilePoint, p float64) time.Duration {
+	if p <= points[0].percentile {
+		return points[0].latency
+	}
+	for i := 1; i < len(points); i++ {
+		lo, hi := points[i-1], points[i]
+		if p <= hi.percentile {
+			frac := (p - lo.percentile) / (hi.percentile - lo.percentile)
+			return lo.latency + time.Duration(frac*float64(hi.latency-lo.latency))
+		}
+	}
+	return points[len(points)-1].latency
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustParse(t *testing.T, data string) *ZoneConfig {
+	t.Helper()
+	cfg, err := ParseZoneConfig([]byte(data))
+	if err != nil {
+		t.Fatalf("parsing %q: %v", data, err)
+	}
+	return cfg
+}
+
+func TestLatencySample(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		min, max time.Duration
+	}{
+		{
+			name:   "fixed",
+			config: "b:\n  200: 1\n  latency: {type: fixed, value: 20ms}\n",
+			min:    20 * time.Millisecond, max: 20 * time.Millisecond,
+		},
+		{
+			name:   "uniform",
+			config: "b:\n  200: 1\n  latency: {type: uniform, min: 10ms, max: 30ms}\n",
+			min:    10 * time.Millisecond, max: 30 * time.Millisecond,
+		},
+		{
+			name:   "normal is never negative",
+			config: "b:\n  200: 1\n  latency: {type: normal, mean: 1ms, stddev: 100ms}\n",
+			min:    0, max: time.Hour,
+		},
+		{
+			name:   "percentiles",
+			config: "b:\n  200: 1\n  latency:\n    type: percentiles\n    percentiles: {99: 500ms, 50: 20ms}\n",
+			min:    20 * time.Millisecond, max: 500 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := mustParse(t, tt.config)
+			for range 1000 {
+				if d := cfg.GetLatency("us-east1-b", ""); d < tt.min || d > tt.max {
+					t.Fatalf("latency %s is outside of [%s, %s]", d, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	cfg := mustParse(t, "b:\n  200: 1\n  latency:\n    type: percentiles\n    percentiles: {100: 1s, 50: 100ms, 90: 500ms}\n")
+	latency := (*cfg)["b"].Latency
+	// Validate keeps the parsed and sorted points for Sample
+	want := []percentilePoint{{50, 100 * time.Millisecond}, {90, 500 * time.Millisecond}, {100, time.Second}}
+	if len(latency.points) != len(want) {
+		t.Fatalf("got points %v, want %v", latency.points, want)
+	}
+	for i := range want {
+		if latency.points[i] != want[i] {
+			t.Fatalf("got points %v, want %v", latency.points, want)
+		}
+	}
+
+	tests := []struct {
+		percentile float64
+		want       time.Duration
+	}{
+		{10, 100 * time.Millisecond},
+		{50, 100 * time.Millisecond},
+		{70, 300 * time.Millisecond},
+		{95, 750 * time.Millisecond},
+		{100, time.Second},
+	}
+	for _, tt := range tests {
+		if got := interpolate(latency.points, tt.percentile); got != tt.want {
+			t.Errorf("got %s at p%g, want %s", got, tt.percentile, tt.want)
+		}
+	}
+}
+
+func TestLatencyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		latency Latency
+		wantErr string
+	}{
+		{name: "negative fixed", latency: Latency{Type: LatencyFixed, Value: -1}, wantErr: "cannot be negative"},
+		{name: "no percentiles", latency: Latency{Type: LatencyPercentiles}, wantErr: "at least one percentile"},
+		{
+			name:    "percentile out of range",
+			latency: Latency{Type: LatencyPercentiles, Percentiles: map[string]time.Duration{"101": time.Second}},
+			wantErr: `invalid percentile "101"`,
+		},
+		{
+			name:    "decreasing latency",
+			latency: Latency{Type: LatencyPercentiles, Percentiles: map[string]time.Duration{"50": time.Second, "99": time.Millisecond}},
+			wantErr: "must not decrease",
+		},
+		{name: "unknown type", latency: Latency{Type: "gamma"}, wantErr: "unknown latency type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.latency.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error with %q", err, tt.wantErr)
+			}
+		})
+	}
+}
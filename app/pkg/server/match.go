@@ -0,0 +1,165 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Zone config keys are matched against zone names, the first kind that
+// matches wins:
+//
+//	us-east1-b:         exact zone name
+//	"eu-west-1*":       glob pattern, the longest matching pattern wins
+//	"/^eu-west-1[ab]$/": regular expression between slashes
+//	region:eu-west-1:   every zone in a region
+//	b:                  the part after the last hyphen, for older configs
+//	default:            zones nothing else matched
+//
+// The same rules apply to the client zones of from overrides. Entries of a
+// fault scenario phase are matched first, so a phase entry b applies to
+// us-east1-b even when the file has an entry for us-east1-b itself.
+const (
+	DefaultZoneKey = "default"
+	regionPrefix   = "region:"
+)
+
+// regexps caches compiled patterns, matching runs on every request
+var regexps sync.Map
+
+func isRegexKey(key string) bool {
+	return len(key) > 2 && strings.HasPrefix(key, "/") && strings.HasSuffix(key, "/")
+}
+
+func isGlobKey(key string) bool {
+	return strings.ContainsAny(key, "*?[")
+}
+
+func compileRegexKey(key string) (*regexp.Regexp, error) {
+	if re, ok := regexps.Load(key); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(key[1 : len(key)-1])
+	if err != nil {
+		return nil, err
+	}
+	regexps.Store(key, re)
+	return re, nil
+}
+
+// validateKey checks that a pattern key compiles
+func validateKey(key string) error {
+	switch {
+	case isRegexKey(key):
+		if _, err := compileRegexKey(key); err != nil {
+			return fmt.Errorf("invalid regular expression %s: %v", key, err)
+		}
+	case strings.HasPrefix(key, regionPrefix):
+		if strings.TrimPrefix(key, regionPrefix) == "" {
+			return fmt.Errorf("region key needs a region name")
+		}
+	case isGlobKey(key):
+		if _, err := path.Match(key, ""); err != nil {
+			return fmt.Errorf("invalid glob pattern %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// Region returns the region of a zone name: us-east1 for the GKE zone
+// us-east1-b, eu-west-1 for the EKS zone eu-west-1a and eastus for the AKS
+// zone eastus-1. Names in none of these forms are their own region.
+func Region(zoneName string) string {
+	if i := strings.LastIndex(zoneName, "-"); i > 0 && len(zoneName)-i == 2 {
+		return zoneName[:i]
+	}
+	if n := len(zoneName); n > 1 && isLower(zoneName[n-1]) && isDigit(zoneName[n-2]) {
+		return zoneName[:n-1]
+	}
+	return zoneName
+}
+
+func isLower(c byte) bool {
+	return c >= 'a' && c <= 'z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// zoneSuffix returns the part after the last hyphen, e.g. b for us-east1-b
+func zoneSuffix(zoneName string) string {
+	if lastHyphen := strings.LastIndex(zoneName, "-"); lastHyphen >= 0 {
+		return zoneName[lastHyphen+1:]
+	}
+	return zoneName
+}
+
+// matchKey returns the key of entries that applies to zoneName
+func matchKey[T any](entries map[string]T, zoneName string) (string, bool) {
+	return matchKeyWhere(entries, zoneName, nil)
+}
+
+// matchKeyWhere is matchKey over the entries include accepts, nil accepts all
+func matchKeyWhere[T any](entries map[string]T, zoneName string, include func(T) bool) (string, bool) {
+	has := func(key string) bool {
+		entry, ok := entries[key]
+		return ok && (include == nil || include(entry))
+	}
+	if has(zoneName) {
+		return zoneName, true
+	}
+
+	var patterns []string
+	for key, entry := range entries {
+		if include != nil && !include(entry) {
+			continue
+		}
+		if isRegexKey(key) {
+			re, err := compileRegexKey(key)
+			if err == nil && re.MatchString(zoneName) {
+				patterns = append(patterns, key)
+			}
+		} else if isGlobKey(key) && !strings.HasPrefix(key, regionPrefix) {
+			if ok, _ := path.Match(key, zoneName); ok {
+				patterns = append(patterns, key)
+			}
+		}
+	}
+	if len(patterns) > 0 {
+		// the longest pattern is usually the most specific one, ties are broken
+		// by name so every server picks the same entry
+		sort.Slice(patterns, func(i, j int) bool {
+			if len(patterns[i]) != len(patterns[j]) {
+				return len(patterns[i]) > len(patterns[j])
+			}
+			return patterns[i] < patterns[j]
+		})
+		return patterns[0], true
+	}
+
+	if zoneName != "" {
+		if key := regionPrefix + Region(zoneName); has(key) {
+			return key, true
+		}
+	}
+	if key := zoneSuffix(zoneName); key != zoneName && has(key) {
+		return key, true
+	}
+	if has(DefaultZoneKey) {
+		return DefaultZoneKey, true
+	}
+	return "", false
+}
+
+// Match returns the key of the zone entry that applies to zoneName, entries
+// of a scenario overlay before those of the file
+func (z ZoneConfig) Match(zoneName string) (string, bool) {
+	if key, ok := matchKeyWhere(z, zoneName, func(zone Zone) bool { return zone.overlay }); ok {
+		return key, true
+	}
+	return matchKey(z, zoneName)
+}
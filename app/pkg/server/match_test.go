@@ -0,0 +1,135 @@
+package server
+
+import "testing"
+
+func TestRegion(t *testing.T) {
+	tests := []struct {
+		zone string
+		want string
+	}{
+		{zone: "us-east1-b", want: "us-east1"},
+		{zone: "eu-west-1a", want: "eu-west-1"},
+		{zone: "eastus-1", want: "eastus"},
+		{zone: "local", want: "local"},
+		{zone: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := Region(tt.zone); got != tt.want {
+			t.Errorf("Region(%q) = %q, want %q", tt.zone, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []string
+		zone    string
+		want    string
+		wantHit bool
+	}{
+		{
+			name:    "exact key before everything",
+			keys:    []string{"us-east1-b", "us-east1-*", "/^us-east1-b$/", "region:us-east1", "b", "default"},
+			zone:    "us-east1-b",
+			want:    "us-east1-b",
+			wantHit: true,
+		},
+		{
+			name:    "longest glob wins",
+			keys:    []string{"us-*", "us-east1-*", "region:us-east1"},
+			zone:    "us-east1-b",
+			want:    "us-east1-*",
+			wantHit: true,
+		},
+		{
+			name:    "equally long patterns are picked by name",
+			keys:    []string{"/east1-b$/", "us-east1-?"},
+			zone:    "us-east1-b",
+			want:    "/east1-b$/",
+			wantHit: true,
+		},
+		{
+			name:    "regex",
+			keys:    []string{"/^eu-west-1[ab]$/", "default"},
+			zone:    "eu-west-1a",
+			want:    "/^eu-west-1[ab]$/",
+			wantHit: true,
+		},
+		{
+			name:    "regex not matching",
+			keys:    []string{"/^eu-west-1[ab]$/", "default"},
+			zone:    "eu-west-1c",
+			want:    "default",
+			wantHit: true,
+		},
+		{
+			name:    "region before suffix",
+			keys:    []string{"region:eu-west-1", "a"},
+			zone:    "eu-west-1a",
+			want:    "region:eu-west-1",
+			wantHit: true,
+		},
+		{
+			name:    "suffix before default",
+			keys:    []string{"b", "default"},
+			zone:    "us-east1-b",
+			want:    "b",
+			wantHit: true,
+		},
+		{
+			name:    "suffix of another zone",
+			keys:    []string{"c"},
+			zone:    "us-east1-b",
+			wantHit: false,
+		},
+		{
+			name:    "empty zone only matches default",
+			keys:    []string{"region:", "default"},
+			zone:    "",
+			want:    "default",
+			wantHit: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := ZoneConfig{}
+			for _, key := range tt.keys {
+				cfg[key] = Zone{Weights: map[int]int{200: 1}}
+			}
+			got, ok := cfg.Match(tt.zone)
+			if ok != tt.wantHit || got != tt.want {
+				t.Errorf("Match(%q) = %q, %v, want %q, %v", tt.zone, got, ok, tt.want, tt.wantHit)
+			}
+		})
+	}
+}
+
+func TestMatchOverlayPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		// file and overlay keys, overlay entries win over every file entry
+		file, overlay []string
+		zone          string
+		want          string
+	}{
+		{name: "overlay suffix over file exact key", file: []string{"us-east1-b"}, overlay: []string{"b"}, zone: "us-east1-b", want: "b"},
+		{name: "overlay default over file exact key", file: []string{"us-east1-b"}, overlay: []string{"default"}, zone: "us-east1-b", want: "default"},
+		{name: "file exact key without a matching overlay", file: []string{"us-east1-b"}, overlay: []string{"c"}, zone: "us-east1-b", want: "us-east1-b"},
+		{name: "overlay entries follow the usual order", file: nil, overlay: []string{"b", "us-east1-b"}, zone: "us-east1-b", want: "us-east1-b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := ZoneConfig{}
+			for _, key := range tt.file {
+				cfg[key] = Zone{Weights: map[int]int{200: 1}}
+			}
+			for _, key := range tt.overlay {
+				cfg[key] = Zone{Weights: map[int]int{500: 1}, overlay: true}
+			}
+			if got, _ := cfg.Match(tt.zone); got != tt.want {
+				t.Errorf("Match(%q) = %q, want %q", tt.zone, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/Tsonov/cast-taler/app/pkg/metrics"
+)
+
+// Scenario is a list of timed phases, each replacing some zones of the zone
+// config while it is active, e.g.
+//
+//	start_at: 2025-07-01T10:00:00Z
+//	phases:
+//	  - name: baseline
+//	    duration: 10m
+//	  - name: b-failing
+//	    duration: 10m
+//	    zones:
+//	      b:
+//	        200: 50
+//	        500: 50
+//	  - name: b-blackholed
+//	    duration: 10m
+//	    zones:
+//	      b:
+//	        blackhole: 100
+//	        blackhole-for: 30s
+//
+// Without start_at the scenario starts with the process. Setting it keeps all
+// server pods on the same clock, so runs are comparable.
+type Scenario struct {
+	StartAt *time.Time `yaml:"start_at"`
+	// Loop restarts the scenario after the last phase, otherwise the zone
+	// config from the file is used again
+	Loop   bool    `yaml:"loop"`
+	Phases []Phase `yaml:"phases"`
+}
+
+type Phase struct {
+	Name     string        `yaml:"name"`
+	Duration time.Duration `yaml:"duration"`
+	Zones    ZoneConfig    `yaml:"zones"`
+}
+
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// a typo like phase for phases would otherwise leave the scenario empty
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var s Scenario
+	if err := dec.Decode(&s); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &s, nil
+}
+
+func (s *Scenario) Validate() error {
+	if len(s.Phases) == 0 {
+		return fmt.Errorf("scenario needs at least one phase")
+	}
+	names := map[string]bool{}
+	for i, phase := range s.Phases {
+		if phase.Name == "" {
+			return fmt.Errorf("phase %d needs a name", i)
+		}
+		if names[phase.Name] {
+			return fmt.Errorf("duplicate phase name %s", phase.Name)
+		}
+		names[phase.Name] = true
+		if phase.Duration <= 0 {
+			return fmt.Errorf("phase %s needs a positive duration", phase.Name)
+		}
+		if err := phase.Zones.Validate(); err != nil {
+			return fmt.Errorf("phase %s: %w", phase.Name, err)
+		}
+	}
+	return nil
+}
+
+// phaseAt returns the index of the phase active after elapsed and how long
+// it stays active, -1 before the start and after the end of the scenario
+func (s *Scenario) phaseAt(elapsed time.Duration) (int, time.Duration) {
+	if elapsed < 0 {
+		return -1, -elapsed
+	}
+	var total time.Duration
+	for _, phase := range s.Phases {
+		total += phase.Duration
+	}
+	if elapsed >= total {
+		if !s.Loop {
+			return -1, 0
+		}
+		elapsed %= total
+	}
+	for i, phase := range s.Phases {
+		if elapsed < phase.Duration {
+			return i, phase.Duration - elapsed
+		}
+		elapsed -= phase.Duration
+	}
+	return -1, 0
+}
+
+// ScenarioRunner applies the phases of a scenario to a ZoneConfigStore
+type ScenarioRunner struct {
+	log      *slog.Logger
+	scenario *Scenario
+	store    *ZoneConfigStore
+}
+
+func NewScenarioRunner(log *slog.Logger, scenario *Scenario, store *ZoneConfigStore) *ScenarioRunner {
+	return &ScenarioRunner{
+		log:      log,
+		scenario: scenario,
+		store:    store,
+	}
+}
+
+func (r *ScenarioRunner) Run(ctx context.Context) error {
+	start := time.Now()
+	if r.scenario.StartAt != nil {
+		start = *r.scenario.StartAt
+	}
+	r.log.Info("Running fault scenario", slog.Time("start", start), slog.Int("phases", len(r.scenario.Phases)))
+	defer r.store.SetOverlay(nil)
+
+	current := -2
+	for {
+		index, remaining := r.scenario.phaseAt(time.Since(start))
+		if index != current {
+			r.apply(index)
+			current = index
+		}
+		if index == -1 && remaining == 0 {
+			r.log.Info("Fault scenario finished")
+			<-ctx.Done()
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(remaining):
+		}
+	}
+}
+
+func (r *ScenarioRunner) apply(index int) {
+	if index == -1 {
+		r.store.SetOverlay(nil)
+		metrics.SetScenarioPhase("")
+		r.log.Info("No fault scenario phase active, using the zone config file")
+		return
+	}
+	phase := r.scenario.Phases[index]
+	version := r.store.SetOverlay(phase.Zones)
+	metrics.SetScenarioPhase(phase.Name)
+	r.log.Info("Fault scenario phase started", slog.String("phase", phase.Name), slog.Int("index", index), slog.Duration("duration", phase.Duration), slog.Int64("zone-config-version", version))
+}
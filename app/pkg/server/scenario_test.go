@@ -0,0 +1,96 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadScenario(t *testing.T) {
+	tests := []struct {
+		name       string
+		scenario   string
+		wantPhases int
+		wantErr    string
+	}{
+		{
+			name:       "valid",
+			scenario:   "loop: true\nphases:\n  - name: baseline\n    duration: 1m\n  - name: failing\n    duration: 1m\n    zones:\n      b: {500: 1}\n",
+			wantPhases: 2,
+		},
+		{
+			name:     "typo in a top level key",
+			scenario: "phase:\n  - name: baseline\n    duration: 1m\n",
+			wantErr:  "field phase not found",
+		},
+		{
+			name:     "typo in a phase key",
+			scenario: "phases:\n  - name: baseline\n    duraton: 1m\n",
+			wantErr:  "field duraton not found",
+		},
+		{
+			name:     "empty",
+			scenario: "",
+			wantErr:  "at least one phase",
+		},
+		{
+			name:     "duplicate phase",
+			scenario: "phases:\n  - name: a\n    duration: 1m\n  - name: a\n    duration: 1m\n",
+			wantErr:  "duplicate phase name a",
+		},
+		{
+			name:     "invalid zone",
+			scenario: "phases:\n  - name: a\n    duration: 1m\n    zones:\n      b: {200: 0}\n",
+			wantErr:  "phase a: invalid zone config",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "scenario.yaml")
+			if err := os.WriteFile(path, []byte(tt.scenario), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			s, err := LoadScenario(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error with %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(s.Phases) != tt.wantPhases {
+				t.Errorf("got %d phases, want %d", len(s.Phases), tt.wantPhases)
+			}
+		})
+	}
+}
+
+func TestScenarioPhaseAt(t *testing.T) {
+	phases := []Phase{{Name: "a", Duration: time.Minute}, {Name: "b", Duration: 2 * time.Minute}}
+	tests := []struct {
+		name          string
+		loop          bool
+		elapsed       time.Duration
+		wantIndex     int
+		wantRemaining time.Duration
+	}{
+		{name: "before the start", elapsed: -time.Second, wantIndex: -1, wantRemaining: time.Second},
+		{name: "first phase", elapsed: 10 * time.Second, wantIndex: 0, wantRemaining: 50 * time.Second},
+		{name: "second phase", elapsed: time.Minute, wantIndex: 1, wantRemaining: 2 * time.Minute},
+		{name: "after the end", elapsed: 3 * time.Minute, wantIndex: -1, wantRemaining: 0},
+		{name: "loop", loop: true, elapsed: 3*time.Minute + 30*time.Second, wantIndex: 0, wantRemaining: 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Scenario{Loop: tt.loop, Phases: phases}
+			index, remaining := s.phaseAt(tt.elapsed)
+			if index != tt.wantIndex || remaining != tt.wantRemaining {
+				t.Errorf("got phase %d for %s, want %d for %s", index, remaining, tt.wantIndex, tt.wantRemaining)
+			}
+		})
+	}
+}
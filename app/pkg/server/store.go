@@ -0,0 +1,85 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/Tsonov/cast-taler/app/pkg/metrics"
+)
+
+// ZoneConfigStore holds the active zone config. Readers call Load once per
+// request and use that snapshot, so a reload never mixes two configs.
+//
+// The active config is the base config from the file with the zones of an
+// optional overlay, set by a fault scenario, taking precedence over the base
+// ones.
+//
+// Every change is exported as zone_config_version, labelled with the source
+// of the base config.
+type ZoneConfigStore struct {
+	current atomic.Pointer[ZoneConfig]
+	version atomic.Int64
+
+	mu      sync.Mutex
+	base    *ZoneConfig
+	overlay ZoneConfig
+	// source is the hash of the file the base config was read from, or
+	// admin when it was set through the admin API
+	source string
+}
+
+func NewZoneConfigStore(cfg *ZoneConfig, source string) *ZoneConfigStore {
+	s := &ZoneConfigStore{}
+	s.Store(cfg, source)
+	return s
+}
+
+func (s *ZoneConfigStore) Load() *ZoneConfig {
+	return s.current.Load()
+}
+
+// Base returns the config without the overlay
+func (s *ZoneConfigStore) Base() *ZoneConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.base
+}
+
+// Store swaps in a new base config and returns the new version, starting at 1
+func (s *ZoneConfigStore) Store(cfg *ZoneConfig, source string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.base = cfg
+	s.source = source
+	return s.publish()
+}
+
+// SetOverlay replaces the overlay, nil removes it
+func (s *ZoneConfigStore) SetOverlay(overlay ZoneConfig) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overlay = overlay
+	return s.publish()
+}
+
+func (s *ZoneConfigStore) Version() int64 {
+	return s.version.Load()
+}
+
+func (s *ZoneConfigStore) publish() int64 {
+	merged := ZoneConfig{}
+	if s.base != nil {
+		for name, zone := range *s.base {
+			merged[name] = zone
+		}
+	}
+	for name, zone := range s.overlay {
+		// overlay entries match first, see Match
+		zone.overlay = true
+		merged[name] = zone
+	}
+	s.current.Store(&merged)
+	version := s.version.Add(1)
+	metrics.SetZoneConfigVersion(float64(version), s.source)
+	return version
+}
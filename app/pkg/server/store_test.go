@@ -0,0 +1,96 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestZoneConfigStoreOverlay(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		overlay string
+		zone    string
+		// wantCode is the only code the zone returns
+		wantCode int
+	}{
+		{
+			name:     "overlay suffix over base exact key",
+			base:     "us-east1-b: {200: 1}",
+			overlay:  "b: {500: 1}",
+			zone:     "us-east1-b",
+			wantCode: 500,
+		},
+		{
+			name:     "overlay suffix over base region",
+			base:     "region:us-east1: {200: 1}",
+			overlay:  "b: {503: 1}",
+			zone:     "us-east1-b",
+			wantCode: 503,
+		},
+		{
+			name:     "overlay glob over base exact key",
+			base:     "us-east1-b: {200: 1}",
+			overlay:  "\"us-east1-*\": {429: 1}",
+			zone:     "us-east1-b",
+			wantCode: 429,
+		},
+		{
+			name:     "same key is replaced",
+			base:     "b: {200: 1}",
+			overlay:  "b: {500: 1}",
+			zone:     "us-east1-b",
+			wantCode: 500,
+		},
+		{
+			name:     "overlay for another zone keeps the base",
+			base:     "us-east1-b: {200: 1}",
+			overlay:  "c: {500: 1}",
+			zone:     "us-east1-b",
+			wantCode: 200,
+		},
+		{
+			name:     "overlay default only applies to unmatched zones",
+			base:     "us-east1-b: {200: 1}",
+			overlay:  "default: {500: 1}",
+			zone:     "us-east1-c",
+			wantCode: 500,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewZoneConfigStore(mustParse(t, tt.base), "base")
+			store.SetOverlay(*mustParse(t, tt.overlay))
+
+			code, err := store.Load().GetRandomCode(tt.zone, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.wantCode {
+				t.Errorf("got %d with the overlay, want %d", code, tt.wantCode)
+			}
+
+			store.SetOverlay(nil)
+			if code, _ := store.Load().GetRandomCode(tt.zone, ""); code == tt.wantCode && tt.wantCode != 200 {
+				t.Errorf("still got %d after removing the overlay", code)
+			}
+		})
+	}
+}
+
+func TestZoneConfigStoreVersion(t *testing.T) {
+	store := NewZoneConfigStore(mustParse(t, "b: {200: 1}"), "base")
+	if v := store.Version(); v != 1 {
+		t.Errorf("got version %d after creation, want 1", v)
+	}
+	store.SetOverlay(*mustParse(t, "b: {500: 1}"))
+	if v := store.Store(mustParse(t, "b: {200: 2}"), "admin"); v != 3 {
+		t.Errorf("got version %d, want 3", v)
+	}
+	if (*store.Base())["b"].Weights[200] != 2 {
+		t.Errorf("base does not hold the stored config")
+	}
+	// storing a new base keeps the overlay
+	if code, _ := store.Load().GetRandomCode("us-east1-b", ""); code != 500 {
+		t.Errorf("got %d, want the overlay code 500", code)
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationError points at a single problem in the zone config file
+type ValidationError struct {
+	Line    int
+	Zone    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("line %d: zone %s: %s", e.Line, e.Zone, e.Message)
+}
+
+// ValidationErrors collects every problem found, so a broken file can be
+// fixed in one go
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid zone config:\n  %s", strings.Join(msgs, "\n  "))
+}
+
+var latencyKeys = []string{"type", "value", "min", "max", "mean", "stddev", "percentiles"}
+
+// Validate checks every zone and returns ValidationErrors sorted by line
+func (z ZoneConfig) Validate() error {
+	var errs ValidationErrors
+	for name, zone := range z {
+		if err := validateKey(name); err != nil {
+			errs = append(errs, ValidationError{Line: zone.Line, Zone: name, Message: err.Error()})
+		}
+		errs = append(errs, zone.validateWithOverrides(name, false)...)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+	return errs
+}
+
+// CheckZones reports keys that apply to none of zones, either because they
+// match none of them, like a typo such as us-east1-bb, or because other keys
+// win for all of them. Such keys silently leave a zone without failures.
+// Default keys are not reported, they are meant for zones nobody listed.
+func (z ZoneConfig) CheckZones(zones []string) error {
+	var errs ValidationErrors
+	unused := func(entries map[string]Zone, name func(key string) string) {
+		used := map[string]bool{}
+		for _, zone := range zones {
+			if key, ok := matchKey(entries, zone); ok {
+				used[key] = true
+			}
+		}
+		for key, entry := range entries {
+			if !used[key] && key != DefaultZoneKey {
+				errs = append(errs, ValidationError{Line: entry.Line, Zone: name(key), Message: fmt.Sprintf("applies to none of the known zones %s", strings.Join(zones, ", "))})
+			}
+		}
+	}
+	unused(z, func(key string) string { return key })
+	for name, zone := range z {
+		unused(zone.From, func(key string) string { return fmt.Sprintf("%s from %s", name, key) })
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+	return errs
+}
+
+// validateWithOverrides checks a zone and its from overrides. Partial zones,
+// that failed to decode, may have no weights.
+func (zone Zone) validateWithOverrides(name string, partial bool) ValidationErrors {
+	errs := zone.validate(name, partial)
+	for client, override := range zone.From {
+		if err := validateKey(client); err != nil {
+			errs = append(errs, ValidationError{Line: override.Line, Zone: name, Message: err.Error()})
+		}
+		errs = append(errs, override.validate(fmt.Sprintf("%s from %s", name, client), true)...)
+	}
+	return errs
+}
+
+// validate checks a single zone, overrides may leave the weights out and
+// inherit them from their zone
+func (zone Zone) validate(name string, override bool) ValidationErrors {
+	var errs ValidationErrors
+	add := func(line int, format string, args ...any) {
+		if line == 0 {
+			line = zone.Line
+		}
+		errs = append(errs, ValidationError{Line: line, Zone: name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	total := 0
+	for code, weight := range zone.Weights {
+		if weight < 0 {
+			add(zone.codeLines[code], "weight of %s cannot be negative", CodeName(code))
+		}
+		total += weight
+	}
+	if total <= 0 && !(override && len(zone.Weights) == 0) {
+		add(zone.Line, "weights must sum to more than 0, every request would fail")
+	}
+	if zone.RetryAfter < 0 {
+		add(zone.retryAfterLine, "retry-after cannot be negative")
+	}
+	if zone.BlackholeFor < 0 {
+		add(zone.blackholeForLine, "blackhole-for cannot be negative")
+	}
+	if err := zone.Latency.Validate(); err != nil {
+		add(zone.latencyLine, "invalid latency: %v", err)
+	}
+	return errs
+}
+
+// checkKnownKeys rejects typos in nested mappings, node.Decode silently
+// ignores unknown keys
+func checkKnownKeys(node *yaml.Node, known []string) ValidationErrors {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	var errs ValidationErrors
+	for i := 0; i < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if !slices.Contains(known, key.Value) {
+			errs = append(errs, ValidationError{Line: key.Line, Message: fmt.Sprintf("unknown key %q, expected one of %s", key.Value, strings.Join(known, ", "))})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseZoneConfigErrors(t *testing.T) {
+	type want struct {
+		line    int
+		zone    string
+		message string
+	}
+	tests := []struct {
+		name   string
+		config string
+		want   []want
+	}{
+		{
+			name:   "valid",
+			config: "b:\n  200: 90\n  reset: 10\n  retry-after: 5s\n",
+		},
+		{
+			name:   "null zone",
+			config: "a:\nb:\n  200: 1\n",
+			want:   []want{{1, "a", "zone is empty"}},
+		},
+		{
+			name:   "every bad code is reported",
+			config: "b:\n  200: 1\n  600: 1\n  foo: 1\n  500: x\n",
+			want: []want{
+				{3, "b", `invalid status code "600"`},
+				{4, "b", `invalid status code "foo"`},
+				{5, "b", "weight of 500 must be a whole number"},
+			},
+		},
+		{
+			name:   "errors of several zones",
+			config: "a:\n  200: -1\nb:\n  reset: 1\n  retry-after: soon\nc:\n  200: 0\n",
+			want: []want{
+				{2, "a", "weight of 200 cannot be negative"},
+				{2, "a", "weights must sum to more than 0"},
+				{5, "b", `invalid retry-after "soon"`},
+				{7, "c", "weights must sum to more than 0"},
+			},
+		},
+		{
+			name:   "latency typo",
+			config: "b:\n  200: 1\n  latency:\n    type: fixed\n    vaule: 10ms\n",
+			want:   []want{{5, "b", `unknown key "vaule"`}},
+		},
+		{
+			name:   "invalid latency",
+			config: "b:\n  200: 1\n  latency:\n    type: uniform\n    min: 2s\n    max: 1s\n",
+			want:   []want{{3, "b", "uniform latency needs 0 <= min <= max"}},
+		},
+		{
+			name:   "overrides",
+			config: "c:\n  200: 1\n  from:\n    a:\n    b:\n      777: 1\n    d:\n      500: -1\n",
+			want: []want{
+				{4, "c from a", "zone is empty"},
+				{6, "c from b", `invalid status code "777"`},
+				{8, "c from d", "weight of 500 cannot be negative"},
+				{8, "c from d", "weights must sum to more than 0"},
+			},
+		},
+		{
+			name:   "bad pattern",
+			config: "\"/[a-/\":\n  200: 1\n",
+			want:   []want{{2, "/[a-/", "invalid regular expression"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseZoneConfig([]byte(tt.config))
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var errs ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("got %v, want ValidationErrors", err)
+			}
+			if len(errs) != len(tt.want) {
+				t.Fatalf("got %d errors, want %d:\n%v", len(errs), len(tt.want), err)
+			}
+			// errors on the same line come in map order
+			for _, w := range tt.want {
+				found := false
+				for _, e := range errs {
+					if e.Line == w.line && e.Zone == w.zone && strings.Contains(e.Message, w.message) {
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.Errorf("missing line %d: zone %s: %s in\n%v", w.line, w.zone, w.message, err)
+				}
+			}
+			for i := 1; i < len(errs); i++ {
+				if errs[i].Line < errs[i-1].Line {
+					t.Errorf("errors are not sorted by line:\n%v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckZones(t *testing.T) {
+	zones := []string{"us-east1-b", "us-east1-c"}
+	tests := []struct {
+		name   string
+		config string
+		// wantZones are the reported zone keys
+		wantZones []string
+	}{
+		{
+			name:   "every key applies",
+			config: "us-east1-b: {200: 1}\nc: {500: 1}\ndefault: {200: 1}\n",
+		},
+		{
+			name:      "typo",
+			config:    "us-east1-bb: {500: 1}\nus-east1-c: {200: 1}\n",
+			wantZones: []string{"us-east1-bb"},
+		},
+		{
+			name:      "shadowed by an exact key",
+			config:    "us-east1-b: {200: 1}\nb: {500: 1}\n",
+			wantZones: []string{"b"},
+		},
+		{
+			name:      "unused override",
+			config:    "us-east1-c:\n  200: 1\n  from:\n    b: {500: 1}\n    eu-west1-a: {500: 1}\n",
+			wantZones: []string{"us-east1-c from eu-west1-a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := mustParse(t, tt.config).CheckZones(zones)
+			if len(tt.wantZones) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var errs ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("got %v, want ValidationErrors", err)
+			}
+			if len(errs) != len(tt.wantZones) {
+				t.Fatalf("got %d errors, want %d:\n%v", len(errs), len(tt.wantZones), err)
+			}
+			for i, zone := range tt.wantZones {
+				if errs[i].Zone != zone || !strings.Contains(errs[i].Message, "applies to none of the known zones") {
+					t.Errorf("got %v, want zone %s", errs[i], zone)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/Tsonov/cast-taler/app/pkg/metrics"
+)
+
+// ConfigWatcher reloads the zone config file when its content changes.
+//
+// Kubernetes updates a mounted ConfigMap by writing a new directory and
+// swapping the ..data symlink, so inotify on the file itself misses the
+// change. Polling the content hash of the resolved file catches the swap as
+// well as plain edits. The file must be mounted without subPath, subPath
+// mounts never see ConfigMap updates.
+type ConfigWatcher struct {
+	log      *slog.Logger
+	path     string
+	store    *ZoneConfigStore
+	interval time.Duration
+	hash     string
+}
+
+func NewConfigWatcher(log *slog.Logger, path string, store *ZoneConfigStore, interval time.Duration) *ConfigWatcher {
+	w := &ConfigWatcher{
+		log:      log,
+		path:     path,
+		store:    store,
+		interval: interval,
+	}
+	// the store was filled from the same file at startup
+	w.hash, _ = FileHash(path)
+	return w
+}
+
+func (w *ConfigWatcher) Run(ctx context.Context) error {
+	w.log.Info("Watching zone config", slog.String("path", w.path), slog.Duration("interval", w.interval))
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+func (w *ConfigWatcher) check() {
+	hash, err := FileHash(w.path)
+	if err != nil {
+		// the symlink swap is not atomic for readers, a missing file is retried on the next tick
+		w.log.Warn("Failed to read zone config", slog.Any("error", err))
+		return
+	}
+	if hash == w.hash {
+		return
+	}
+
+	cfg, err := LoadZoneConfig(w.path)
+	if err != nil {
+		// keep serving the last good config, the hash is not updated so a fixed file is picked up
+		w.log.Error("Ignoring invalid zone config", slog.Any("error", err))
+		metrics.TrackZoneConfigReload(false)
+		return
+	}
+
+	old := w.store.Base()
+	version := w.store.Store(cfg, hash)
+	w.hash = hash
+	metrics.TrackZoneConfigReload(true)
+
+	changes := Diff(*old, *cfg)
+	w.log.Info("Reloaded zone config", slog.Int64("version", version), slog.String("hash", hash), slog.Int("changes", len(changes)))
+	for _, change := range changes {
+		w.log.Info("Zone config changed", slog.String("change", change))
+	}
+}
+
+// FileHash identifies the content of a zone config file in metrics
+func FileHash(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
+}
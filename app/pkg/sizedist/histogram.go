@@ -0,0 +1,96 @@
+package sizedist
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// histogram picks a bucket by weight and a size uniformly inside it
+type histogram struct {
+	// bounds are the upper bounds of the buckets, the first bucket starts at 0
+	bounds []int64
+	// cumulative sums of the bucket weights
+	cumulative []float64
+}
+
+// loadHistogram reads a CSV of "size,weight" rows, e.g. measured RPC sizes:
+//
+//	size,weight
+//	1KiB,40
+//	16KiB,50
+//	10MiB,10
+//
+// Each size is the upper bound of a bucket starting after the previous one.
+// A header row is skipped if its weight column is not a number.
+func loadHistogram(path string) (Distribution, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+
+	h := &histogram{}
+	total := 0.0
+	for first := true; ; first = false {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		// the line in the file, comments and blank lines are not records
+		line, _ := r.FieldPos(0)
+
+		weight, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil {
+			if first {
+				continue
+			}
+			return nil, fmt.Errorf("%s:%d: invalid weight %q", path, line, record[1])
+		}
+		if weight < 0 {
+			return nil, fmt.Errorf("%s:%d: weight cannot be negative", path, line)
+		}
+		size, err := ParseByteSize(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if n := len(h.bounds); n > 0 && int64(size) <= h.bounds[n-1] {
+			return nil, fmt.Errorf("%s:%d: sizes must be increasing", path, line)
+		}
+
+		total += weight
+		h.bounds = append(h.bounds, int64(size))
+		h.cumulative = append(h.cumulative, total)
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("%s: no rows with a weight", path)
+	}
+	return h, nil
+}
+
+func (h *histogram) Sample() int64 {
+	v := rand.Float64() * h.cumulative[len(h.cumulative)-1]
+	// the first bucket whose sum is above v, which never is an empty one
+	i := sort.Search(len(h.cumulative), func(i int) bool {
+		return h.cumulative[i] > v
+	})
+	lower := int64(0)
+	if i > 0 {
+		lower = h.bounds[i-1] + 1
+	}
+	return lower + rand.Int63n(h.bounds[i]-lower+1)
+}
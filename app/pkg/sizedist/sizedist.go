@@ -0,0 +1,240 @@
+package sizedist
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	TypeFixed     = "fixed"
+	TypeUniform   = "uniform"
+	TypeLogNormal = "lognormal"
+	TypePareto    = "pareto"
+	TypeHistogram = "histogram"
+)
+
+// MaxSize caps the samples of lognormal and pareto distributions without a
+// max, their tails go beyond any size that makes sense for a request
+const MaxSize = 16 << 30
+
+// Distribution picks the size of the next payload in bytes
+type Distribution interface {
+	Sample() int64
+}
+
+// Config describes a size distribution, e.g. mostly small calls with a long
+// tail of large ones:
+//
+//	type: lognormal
+//	median: 8KiB
+//	sigma: 1.5
+//	max: 64MiB
+type Config struct {
+	Type string `yaml:"type"`
+
+	// fixed
+	Size ByteSize `yaml:"size"`
+
+	// uniform, and bounds of the other types. Min defaults to 0 and max to
+	// MaxSize outside of uniform.
+	Min ByteSize `yaml:"min"`
+	Max ByteSize `yaml:"max"`
+
+	// lognormal, sigma is the standard deviation of the log of the size
+	Median ByteSize `yaml:"median"`
+	Sigma  float64  `yaml:"sigma"`
+
+	// pareto, sizes start at min and the tail is heavier the lower alpha is
+	Alpha float64 `yaml:"alpha"`
+
+	// histogram, a CSV file of "size,weight" rows, see loadHistogram
+	File string `yaml:"file"`
+}
+
+// Load reads a distribution from a YAML file, a relative histogram file is
+// resolved against the directory of path
+func Load(path string) (Distribution, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing size distribution %s: %w", path, err)
+	}
+	if cfg.File != "" && !filepath.IsAbs(cfg.File) {
+		cfg.File = filepath.Join(filepath.Dir(path), cfg.File)
+	}
+	return cfg.Build()
+}
+
+// Build validates the config and returns the matching distribution
+func (c Config) Build() (Distribution, error) {
+	if c.Min < 0 || c.Max < 0 {
+		return nil, fmt.Errorf("min and max cannot be negative")
+	}
+	if c.Max > 0 && c.Max < c.Min {
+		return nil, fmt.Errorf("max %d is below min %d", c.Max, c.Min)
+	}
+	upper := int64(c.Max)
+	if upper == 0 {
+		upper = MaxSize
+	}
+
+	switch c.Type {
+	case TypeFixed:
+		if c.Size < 0 {
+			return nil, fmt.Errorf("fixed size cannot be negative")
+		}
+		return Fixed(int64(c.Size)), nil
+	case TypeUniform:
+		if c.Max == 0 {
+			return nil, fmt.Errorf("uniform needs max")
+		}
+		return Uniform(int64(c.Min), int64(c.Max)), nil
+	case TypeLogNormal:
+		if c.Median <= 0 {
+			return nil, fmt.Errorf("lognormal needs a positive median")
+		}
+		if c.Sigma <= 0 {
+			return nil, fmt.Errorf("lognormal needs a positive sigma")
+		}
+		return bounded{
+			dist: logNormal{mu: math.Log(float64(c.Median)), sigma: c.Sigma},
+			min:  float64(c.Min),
+			max:  float64(upper),
+		}, nil
+	case TypePareto:
+		if c.Min <= 0 {
+			return nil, fmt.Errorf("pareto needs a positive min")
+		}
+		if c.Alpha <= 0 {
+			return nil, fmt.Errorf("pareto needs a positive alpha")
+		}
+		return bounded{
+			dist: pareto{min: float64(c.Min), alpha: c.Alpha},
+			min:  float64(c.Min),
+			max:  float64(upper),
+		}, nil
+	case TypeHistogram:
+		if c.File == "" {
+			return nil, fmt.Errorf("histogram needs a file")
+		}
+		return loadHistogram(c.File)
+	default:
+		return nil, fmt.Errorf("unknown size distribution type %q", c.Type)
+	}
+}
+
+// Fixed returns the same size every time
+func Fixed(size int64) Distribution {
+	return fixed(size)
+}
+
+type fixed int64
+
+func (f fixed) Sample() int64 {
+	return int64(f)
+}
+
+// Uniform returns sizes in [min, max], both inclusive
+func Uniform(min, max int64) Distribution {
+	return uniform{min: min, max: max}
+}
+
+type uniform struct {
+	min, max int64
+}
+
+func (u uniform) Sample() int64 {
+	return u.min + rand.Int63n(u.max-u.min+1)
+}
+
+// sampler is an unbounded distribution, its samples are floats so they can be
+// clamped before they overflow an int64
+type sampler interface {
+	sample() float64
+}
+
+type logNormal struct {
+	mu, sigma float64
+}
+
+func (l logNormal) sample() float64 {
+	return math.Exp(l.mu + l.sigma*rand.NormFloat64())
+}
+
+type pareto struct {
+	min, alpha float64
+}
+
+func (p pareto) sample() float64 {
+	// 1 - Float64 is in (0, 1], so the division never is a division by zero
+	return p.min / math.Pow(1-rand.Float64(), 1/p.alpha)
+}
+
+// bounded clamps the samples of an unbounded distribution to [min, max]
+type bounded struct {
+	dist     sampler
+	min, max float64
+}
+
+func (b bounded) Sample() int64 {
+	// +Inf is clamped as well, NaN never happens for valid parameters
+	return int64(math.Min(math.Max(b.dist.sample(), b.min), b.max))
+}
+
+// ByteSize is a number of bytes, written either as a plain number or with a
+// unit like 512B, 64KB, 64KiB, 5MB or 1GiB
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	factor int64
+}{
+	// longest suffixes first so KiB is not read as a B suffix
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+func ParseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+	factor := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			factor = unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(n) {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	size := n * float64(factor)
+	if math.Abs(size) >= math.MaxInt64 {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return ByteSize(size), nil
+}
+
+func (b *ByteSize) UnmarshalYAML(node *yaml.Node) error {
+	size, err := ParseByteSize(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*b = size
+	return nil
+}
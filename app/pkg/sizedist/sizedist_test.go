@@ -0,0 +1,172 @@
+package sizedist
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ByteSize
+		wantErr string
+	}{
+		{in: "512", want: 512},
+		{in: "512B", want: 512},
+		{in: "64KB", want: 64000},
+		{in: "64KiB", want: 64 << 10},
+		{in: " 1.5 MiB ", want: 3 << 19},
+		{in: "1GiB", want: 1 << 30},
+		{in: "KiB", wantErr: "invalid size"},
+		{in: "NaN", wantErr: "invalid size"},
+		{in: "Inf", wantErr: "too large"},
+		{in: "1e10GiB", wantErr: "too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseByteSize(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %d, %v, want an error with %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+		// samples must be in [min, max]
+		min, max int64
+	}{
+		{name: "fixed", cfg: Config{Type: TypeFixed, Size: 100}, min: 100, max: 100},
+		{name: "uniform", cfg: Config{Type: TypeUniform, Min: 10, Max: 20}, min: 10, max: 20},
+		{name: "uniform without max", cfg: Config{Type: TypeUniform, Min: 10}, wantErr: "needs max"},
+		{name: "max below min", cfg: Config{Type: TypeUniform, Min: 20, Max: 10}, wantErr: "below min"},
+		{name: "negative min", cfg: Config{Type: TypeUniform, Min: -1, Max: 10}, wantErr: "negative"},
+		{
+			name: "lognormal within bounds",
+			cfg:  Config{Type: TypeLogNormal, Median: 1000, Sigma: 2, Min: 100, Max: 10000},
+			min:  100, max: 10000,
+		},
+		{
+			// the tail of sigma 50 is far beyond int64
+			name: "lognormal without max is capped",
+			cfg:  Config{Type: TypeLogNormal, Median: 1 << 20, Sigma: 50},
+			min:  0, max: MaxSize,
+		},
+		{name: "lognormal without sigma", cfg: Config{Type: TypeLogNormal, Median: 1000}, wantErr: "positive sigma"},
+		{
+			// so is the one of alpha 0.01
+			name: "pareto without max is capped",
+			cfg:  Config{Type: TypePareto, Min: 1000, Alpha: 0.01},
+			min:  1000, max: MaxSize,
+		},
+		{name: "pareto without min", cfg: Config{Type: TypePareto, Alpha: 1}, wantErr: "positive min"},
+		{name: "unknown type", cfg: Config{Type: "normal"}, wantErr: "unknown size distribution type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dist, err := tt.cfg.Build()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error with %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range 10000 {
+				if s := dist.Sample(); s < tt.min || s > tt.max {
+					t.Fatalf("sample %d is outside of [%d, %d]", s, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestBoundedClampsInfinity(t *testing.T) {
+	b := bounded{dist: constSampler(math.Inf(1)), min: 0, max: MaxSize}
+	if s := b.Sample(); s != MaxSize {
+		t.Errorf("got %d, want %d", s, int64(MaxSize))
+	}
+}
+
+type constSampler float64
+
+func (c constSampler) sample() float64 {
+	return float64(c)
+}
+
+func TestLoadHistogram(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		wantErr string
+		// samples must be in [0, max]
+		max int64
+	}{
+		{
+			name: "header and comments",
+			csv:  "size,weight\n# small calls\n1KiB,40\n\n16KiB,60\n",
+			max:  16 << 10,
+		},
+		{
+			name:    "line of a bad weight after comments",
+			csv:     "size,weight\n# small calls\n\n1KiB,40\n16KiB,x\n",
+			wantErr: ":5: invalid weight",
+		},
+		{
+			name:    "line of a decreasing size",
+			csv:     "# sizes\n16KiB,1\n# oops\n1KiB,1\n",
+			wantErr: ":4: sizes must be increasing",
+		},
+		{
+			name:    "negative weight",
+			csv:     "1KiB,-1\n",
+			wantErr: ":1: weight cannot be negative",
+		},
+		{
+			name:    "no weights",
+			csv:     "size,weight\n1KiB,0\n",
+			wantErr: "no rows with a weight",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sizes.csv")
+			if err := os.WriteFile(path, []byte(tt.csv), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			dist, err := Config{Type: TypeHistogram, File: path}.Build()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error with %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range 10000 {
+				if s := dist.Sample(); s < 0 || s > tt.max {
+					t.Fatalf("sample %d is outside of [0, %d]", s, tt.max)
+				}
+			}
+		})
+	}
+}